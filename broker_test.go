@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is an in-process MQTT 3.1.1 broker for the tests: QoS 0 and 1, retained
+// messages and the + and # wildcards, no sessions nor authentication
+type testBroker struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	subs     map[*brokerConn]map[string]byte
+	retained map[string]*packets.PublishPacket
+	// stalled drops publishes and subscribes without replying, as a broker which
+	// accepted the connection but hangs
+	stalled bool
+}
+
+// brokerConn is a client of testBroker, writes are serialized as deliveries come from
+// the connections of the other clients
+type brokerConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+	id   uint16
+}
+
+func (c *brokerConn) write(p packets.ControlPacket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return p.Write(c.conn)
+}
+
+// newTestBroker listens on a free port of localhost until Close
+func newTestBroker(t *testing.T) *testBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{
+		ln:       ln,
+		subs:     make(map[*brokerConn]map[string]byte),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&brokerConn{conn: conn})
+		}
+	}()
+	return b
+}
+
+// URL is the broker address for MQTTHost
+func (b *testBroker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// stall makes the broker stop or resume handling publishes and subscribes
+func (b *testBroker) stall(stalled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stalled = stalled
+}
+
+func (b *testBroker) isStalled() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stalled
+}
+
+func (b *testBroker) Close() {
+	b.ln.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.subs {
+		c.conn.Close()
+	}
+}
+
+func (b *testBroker) serve(c *brokerConn) {
+	b.mu.Lock()
+	b.subs[c] = make(map[string]byte)
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		switch cp.(type) {
+		case *packets.SubscribePacket, *packets.PublishPacket:
+			if b.isStalled() {
+				continue
+			}
+		}
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			c.write(packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			b.mu.Lock()
+			for i, topic := range p.Topics {
+				qos := p.Qoss[i]
+				if qos > 1 {
+					qos = 1
+				}
+				b.subs[c][topic] = qos
+				ack.ReturnCodes = append(ack.ReturnCodes, qos)
+			}
+			var retained []*packets.PublishPacket
+			for _, r := range b.retained {
+				for _, topic := range p.Topics {
+					if matchTopic(topic, r.TopicName) {
+						retained = append(retained, r)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			c.write(ack)
+			for _, r := range retained {
+				b.deliver(c, r, 0, true)
+			}
+		case *packets.UnsubscribePacket:
+			b.mu.Lock()
+			for _, topic := range p.Topics {
+				delete(b.subs[c], topic)
+			}
+			b.mu.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			}
+			b.publish(p)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// publish keeps p if retained and delivers it to each matching subscription
+func (b *testBroker) publish(p *packets.PublishPacket) {
+	b.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			b.retained[p.TopicName] = p
+		}
+	}
+	type delivery struct {
+		c   *brokerConn
+		qos byte
+	}
+	var deliveries []delivery
+	for c, topics := range b.subs {
+		for topic, qos := range topics {
+			if matchTopic(topic, p.TopicName) {
+				deliveries = append(deliveries, delivery{c, qos})
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, d := range deliveries {
+		b.deliver(d.c, p, d.qos, false)
+	}
+}
+
+// deliver sends p to c at the lower of the QoS of p and qos
+func (b *testBroker) deliver(c *brokerConn, p *packets.PublishPacket, qos byte, retained bool) {
+	out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	out.TopicName = p.TopicName
+	out.Payload = p.Payload
+	out.Retain = retained
+	out.Qos = p.Qos
+	if qos < out.Qos {
+		out.Qos = qos
+	}
+	if out.Qos > 0 {
+		c.mu.Lock()
+		c.id++
+		out.MessageID = c.id
+		c.mu.Unlock()
+	}
+	c.write(out)
+}
+
+// matchTopic tells whether topic matches the subscription filter
+func matchTopic(filter, topic string) bool {
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
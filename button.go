@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/djthorpe/gopi"
+	"time"
+)
+
+// button watches a GPIO pin wired to a push button pulling it down to ground
+type button struct {
+	gpio      gopi.GPIO
+	pin       gopi.GPIOPin
+	events    <-chan gopi.Event
+	pressedAt time.Time
+}
+
+func newButton(gpio gopi.GPIO, pin gopi.GPIOPin) (*button, error) {
+	gpio.SetPinMode(pin, gopi.GPIO_INPUT)
+	if err := gpio.SetPullMode(pin, gopi.GPIO_PULL_UP); err != nil && err != gopi.ErrNotImplemented {
+		return nil, err
+	}
+	if err := gpio.Watch(pin, gopi.GPIO_EDGE_FALLING); err != nil {
+		return nil, err
+	}
+	return &button{
+		gpio:   gpio,
+		pin:    pin,
+		events: gpio.Subscribe(),
+	}, nil
+}
+
+// pressed reports whether evt is a press of the button, ignoring bounces within ButtonDebounce
+func (b *button) pressed(evt gopi.Event) bool {
+	e, ok := evt.(gopi.GPIOEvent)
+	if !ok || e.Pin() != b.pin || e.Edge() != gopi.GPIO_EDGE_FALLING {
+		return false
+	}
+	if time.Since(b.pressedAt) < ButtonDebounce {
+		return false
+	}
+	b.pressedAt = time.Now()
+	return true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"io/ioutil"
+	"strings"
+)
+
+// CapabilityOverride adjusts Spec to a variant of the unit, read from CapabilitiesFile.
+// Omitted fields keep the built-in values. Only values the remote can encode can be
+// listed, so an override narrows what is accepted or restores it up to the encoder.
+type CapabilityOverride struct {
+	// Mode sets the temperature range by mode name, null removes the mode
+	Mode map[string]*TempRange `json:"mode"`
+	// Fan and Swing list the accepted names
+	Fan   []string `json:"fan"`
+	Swing []string `json:"swing"`
+	// MaxTimerHour of 0 removes the timer powers
+	MaxTimerHour *byte `json:"max_timer_hour"`
+}
+
+// loadCapabilities merges the override in path onto m
+func (m *ModelSpec) loadCapabilities(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var o CapabilityOverride
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&o); err != nil {
+		return err
+	}
+	return m.apply(&o)
+}
+
+// apply validates o and merges it onto m, which is left unchanged on error
+func (m *ModelSpec) apply(o *CapabilityOverride) error {
+	modes := copyValues(m.Modes)
+	ranges := make(map[byte]TempRange, len(m.TempRanges))
+	for mode, r := range m.TempRanges {
+		ranges[mode] = r
+	}
+	for name, r := range o.Mode {
+		mode, ok := modeValues[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("invalid mode: %s (valid: %s)", name, strings.Join(sortedNames(modeValues), ", "))
+		}
+		if r == nil {
+			delete(modes, strings.ToLower(name))
+			delete(ranges, mode)
+			continue
+		}
+		if r.Min < MinTemp || r.Max > MaxTemp || r.Min > r.Max {
+			return fmt.Errorf("invalid range for %s: %d-%d (%d-%d)", name, r.Min, r.Max, MinTemp, MaxTemp)
+		}
+		modes[strings.ToLower(name)] = mode
+		ranges[mode] = TempRange{r.Min, r.Min + (r.Max-r.Min)/m.TempStep*m.TempStep}
+	}
+	if len(modes) == 0 {
+		return errors.New("no mode left")
+	}
+
+	airVolumes, err := pickValues("fan", o.Fan, m.AirVolumes, airVolumeValues)
+	if err != nil {
+		return err
+	}
+	windDirections, err := pickValues("swing", o.Swing, m.WindDirections, windDirectionValues)
+	if err != nil {
+		return err
+	}
+
+	powers := copyValues(m.Powers)
+	maxTimerHour := m.MaxTimerHour
+	if o.MaxTimerHour != nil {
+		if *o.MaxTimerHour > m.MaxTimerHour {
+			return fmt.Errorf("invalid max_timer_hour: %d (0-%d)", *o.MaxTimerHour, m.MaxTimerHour)
+		}
+		maxTimerHour = *o.MaxTimerHour
+		if maxTimerHour == 0 {
+			for name, v := range powers {
+				if v == A75C4269.PowerOnAndOffTimer || v == A75C4269.PowerOffAndOnTimer {
+					delete(powers, name)
+				}
+			}
+		}
+	}
+
+	m.Modes = modes
+	m.TempRanges = ranges
+	m.AirVolumes = airVolumes
+	m.WindDirections = windDirections
+	m.Powers = powers
+	m.MaxTimerHour = maxTimerHour
+	return nil
+}
+
+// pickValues returns the values of names taken from all, or current when names is nil
+func pickValues(field string, names []string, current, all map[string]byte) (map[string]byte, error) {
+	if names == nil {
+		return current, nil
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no %s left", field)
+	}
+	values := make(map[string]byte, len(names))
+	for _, name := range names {
+		v, ok := all[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: %s (valid: %s)", field, name, strings.Join(sortedNames(all), ", "))
+		}
+		values[strings.ToLower(name)] = v
+	}
+	return values, nil
+}
+
+func copyValues(values map[string]byte) map[string]byte {
+	c := make(map[string]byte, len(values))
+	for name, v := range values {
+		c[name] = v
+	}
+	return c
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+// testSpec returns a copy of Spec with its own temperature ranges, which apply and
+// SetTempRange may replace or modify without touching Spec
+func testSpec() *ModelSpec {
+	m := *Spec
+	m.TempRanges = make(map[byte]TempRange, len(Spec.TempRanges))
+	for mode, r := range Spec.TempRanges {
+		m.TempRanges[mode] = r
+	}
+	return &m
+}
+
+func writeCapabilities(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "capabilities.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCapabilities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := testSpec()
+	path := writeCapabilities(t, dir, `{"mode":{"cool":{"min":18,"max":28},"dry":null},"fan":["auto","1"],"max_timer_hour":0}`)
+	if err := m.loadCapabilities(path); err != nil {
+		t.Fatal(err)
+	}
+
+	c := m.Capabilities()
+	wantModes := map[string]TempRange{"cool": {18, 28}, "heat": {MinTemp, MaxTemp}}
+	if !reflect.DeepEqual(c.Mode, wantModes) {
+		t.Errorf("mode: got %v, want %v", c.Mode, wantModes)
+	}
+	if want := []string{"1", "auto"}; !reflect.DeepEqual(c.Fan, want) {
+		t.Errorf("fan: got %v, want %v", c.Fan, want)
+	}
+	if !reflect.DeepEqual(c.Swing, Spec.Capabilities().Swing) {
+		t.Errorf("swing: got %v, want the built-in names", c.Swing)
+	}
+	if c.MaxTimerHour != 0 {
+		t.Errorf("max_timer_hour: got %d", c.MaxTimerHour)
+	}
+	for _, name := range c.Power {
+		if v := powerValues[name]; v == A75C4269.PowerOnAndOffTimer || v == A75C4269.PowerOffAndOnTimer {
+			t.Errorf("power: timer %s left", name)
+		}
+	}
+
+	// the validation follows the advertised values
+	checks := []struct {
+		name string
+		c    A75C4269.Controller
+		err  string
+	}{
+		{"within the range", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 18}, ""},
+		{"below the range", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 17}, "temperature out of range for cool: 17 (18-28)"},
+		{"above the range", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 29}, "temperature out of range for cool"},
+		{"removed mode", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeDehumidifier, PresetTemp: 26}, "invalid mode"},
+		{"removed fan", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume3}, "invalid fan"},
+		{"removed timer", A75C4269.Controller{Power: A75C4269.PowerOnAndOffTimer, Mode: A75C4269.ModeCooler, PresetTemp: 26, TimerHour: 1}, "invalid power"},
+	}
+	for _, tt := range checks {
+		err := m.Check(&tt.c)
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestApplyCapabilitiesRange(t *testing.T) {
+	// TEMP_MIN/TEMP_MAX narrowed the built-in ranges, an override may restore them
+	// up to what the remote can encode
+	m := testSpec()
+	if err := m.SetTempRange(20, 26, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.apply(&CapabilityOverride{Mode: map[string]*TempRange{
+		"cool": {MinTemp, MaxTemp},
+		"heat": {18, 25},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]TempRange{"cool": {16, 30}, "heat": {18, 24}, "dry": {20, 26}}
+	if got := m.Capabilities().Mode; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestApplyCapabilitiesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"below the encoder", `{"mode":{"cool":{"min":15,"max":30}}}`, "invalid range for cool: 15-30 (16-30)"},
+		{"above the encoder", `{"mode":{"heat":{"min":16,"max":31}}}`, "invalid range for heat: 16-31 (16-30)"},
+		{"reversed", `{"mode":{"cool":{"min":28,"max":18}}}`, "invalid range for cool"},
+		{"unknown mode", `{"mode":{"fan_only":{"min":16,"max":30}}}`, "invalid mode: fan_only"},
+		{"every mode removed", `{"mode":{"cool":null,"heat":null,"dry":null}}`, "no mode left"},
+		{"unknown fan", `{"fan":["auto","turbo"]}`, "invalid fan: turbo"},
+		{"no swing", `{"swing":[]}`, "no swing left"},
+		{"timer too long", `{"max_timer_hour":13}`, "invalid max_timer_hour: 13 (0-12)"},
+		{"unknown field", `{"modes":{}}`, "unknown field"},
+	}
+	for _, tt := range tests {
+		m := testSpec()
+		before := m.Capabilities()
+		err := m.loadCapabilities(writeCapabilities(t, dir, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+		if after := m.Capabilities(); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: spec changed to %+v", tt.name, after)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+)
+
+const (
+	// ActionResend re-emits the last successfully sent state
+	ActionResend = "resend"
+	// ActionSleepCurve emits the command and then moves its temperature step by step over the night
+	ActionSleepCurve = "sleep_curve"
+	// ActionVacation powers off and keeps the unit off until the given time, but for
+	// maintenance cycles and anti-freeze heating
+	ActionVacation = "vacation"
+)
+
+const (
+	// longest boost accepted in a boost command
+	MaxBoostMinutes = 120
+
+	// range of PresetTemp supported by A75C4269, see Spec
+	MinTemp = 16
+	MaxTemp = 30
+)
+
+const (
+	// limits of a sleep curve
+	MaxSleepSteps   = 8
+	MaxSleepMinutes = 600
+
+	// default sleep curve: a step of Spec.TempStep towards the outside temperature every
+	// hour for 3 hours
+	defaultSleepSteps    = 3
+	defaultSleepInterval = 60
+)
+
+const (
+	// maximum number of timings accepted in a raw frame
+	MaxRawLength = 1024
+	// maximum duration of a single pulse or space in microseconds
+	MaxRawTiming = 100000
+)
+
+// Command is a message received on SubTopic
+type Command struct {
+	A75C4269.Controller
+	Action string   `json:"action,omitempty"`
+	Preset string   `json:"preset,omitempty"`
+	Raw    []uint32 `json:"raw,omitempty"`
+	Boost  *Boost   `json:"boost,omitempty"`
+	Then   *Settle  `json:"then,omitempty"`
+	// Setpoint leaves the choice of the mode to the service
+	Setpoint *Setpoint `json:"setpoint,omitempty"`
+	// Steps of a sleep curve, the default curve is used when empty
+	Steps []SleepStep `json:"steps,omitempty"`
+	// Force sends a temperature change smaller than MinTempChange
+	Force bool `json:"force,omitempty"`
+	// Until is the end of a vacation command
+	Until *LocalTime `json:"until,omitempty"`
+	// Notify false sends the command without notifying, true by default
+	Notify *bool `json:"notify,omitempty"`
+
+	// powerOnly is set when the payload has no field but power
+	powerOnly bool
+	// requestedTemp is the temp of the payload when it was snapped to a step
+	requestedTemp *float64
+}
+
+// Boost is the first step of a boost command, emitted immediately
+type Boost struct {
+	Temp    uint `json:"temp"`
+	Minutes uint `json:"minutes"`
+}
+
+// Settle is the state a boost command settles to once the boost is over
+type Settle struct {
+	Temp uint `json:"temp"`
+}
+
+// SleepStep is a temperature a sleep curve moves to, Minutes after the command
+type SleepStep struct {
+	Minutes uint `json:"minutes"`
+	Temp    uint `json:"temp"`
+}
+
+// Resolve returns the controller state to emit for the command
+func (cmd *Command) Resolve(last *A75C4269.Controller) (*A75C4269.Controller, error) {
+	if len(cmd.Preset) > 0 {
+		if len(cmd.Action) > 0 {
+			return nil, errors.New("preset cannot be combined with action")
+		}
+		return lookupPreset(cmd.Preset)
+	}
+
+	switch cmd.Action {
+	case "":
+		c := cmd.Controller
+		return &c, nil
+	case ActionResend:
+		if last == nil {
+			return nil, errors.New("no previous state to resend")
+		}
+		c := *last
+		return &c, nil
+	default:
+		return nil, fmt.Errorf("unknown action: %s", cmd.Action)
+	}
+}
+
+// Restore returns lastOn powered on for a command with power on only, as a physical
+// remote turns back on as it was. It returns nil for any other command.
+func (cmd *Command) Restore(lastOn *A75C4269.Controller) *A75C4269.Controller {
+	if !cmd.powerOnly || cmd.Power != A75C4269.PowerOn || lastOn == nil {
+		return nil
+	}
+	c := *lastOn
+	c.Power = A75C4269.PowerOn
+	return &c
+}
+
+// ValidateRaw checks that the raw timings can be sent by LIRC as is. They are not
+// checked as an A75C4269 frame, raw is meant for the signals it does not model.
+func (cmd *Command) ValidateRaw() error {
+	if len(cmd.Action) > 0 {
+		return errors.New("raw cannot be combined with action")
+	}
+	if len(cmd.Raw) > MaxRawLength {
+		return fmt.Errorf("raw frame too long: %d timings (max %d)", len(cmd.Raw), MaxRawLength)
+	}
+	if len(cmd.Raw)%2 == 0 {
+		return fmt.Errorf("raw frame must have an odd number of timings: %d", len(cmd.Raw))
+	}
+	for i, t := range cmd.Raw {
+		if t == 0 || t > MaxRawTiming {
+			return fmt.Errorf("raw timing out of range at %d: %d (1-%d)", i, t, MaxRawTiming)
+		}
+	}
+	return nil
+}
+
+// ResolveBoost returns the boost and settle states of a boost command, both are based on
+// the other fields of the command and powered on
+func (cmd *Command) ResolveBoost() (boost, settle *A75C4269.Controller, err error) {
+	if len(cmd.Action) > 0 {
+		return nil, nil, errors.New("boost cannot be combined with action")
+	}
+	if cmd.Then == nil {
+		return nil, nil, errors.New("boost requires then")
+	}
+	if cmd.Boost.Minutes == 0 || cmd.Boost.Minutes > MaxBoostMinutes {
+		return nil, nil, fmt.Errorf("boost minutes out of range: %d (1-%d)", cmd.Boost.Minutes, MaxBoostMinutes)
+	}
+
+	b := cmd.Controller
+	b.Power = A75C4269.PowerOn
+	b.PresetTemp = cmd.Boost.Temp
+	s := b
+	s.PresetTemp = cmd.Then.Temp
+	for _, c := range []*A75C4269.Controller{&b, &s} {
+		if err := Spec.Check(c); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &b, &s, nil
+}
+
+// ResolveSleepCurve returns the powered on state to emit now and the steps of a sleep curve command
+func (cmd *Command) ResolveSleepCurve() (*A75C4269.Controller, []SleepStep, error) {
+	c := cmd.Controller
+	c.Power = A75C4269.PowerOn
+
+	steps := cmd.Steps
+	if len(steps) == 0 {
+		var delta int
+		switch c.Mode {
+		case A75C4269.ModeCooler:
+			delta = int(Spec.TempStep)
+		case A75C4269.ModeHeater:
+			delta = -int(Spec.TempStep)
+		default:
+			return nil, nil, errors.New("sleep curve requires steps except in cooler or heater mode")
+		}
+		t := int(c.PresetTemp)
+		r := Spec.TempRanges[c.Mode]
+		for i := 1; i <= defaultSleepSteps; i++ {
+			if t+delta >= int(r.Min) && t+delta <= int(r.Max) {
+				t += delta
+			}
+			steps = append(steps, SleepStep{Minutes: uint(i * defaultSleepInterval), Temp: uint(t)})
+		}
+	}
+
+	if len(steps) > MaxSleepSteps {
+		return nil, nil, fmt.Errorf("too many sleep curve steps: %d (max %d)", len(steps), MaxSleepSteps)
+	}
+	var prev uint
+	for i, step := range steps {
+		if step.Minutes <= prev || step.Minutes > MaxSleepMinutes {
+			return nil, nil, fmt.Errorf("sleep curve step %d: minutes must increase within 1-%d: %d", i+1, MaxSleepMinutes, step.Minutes)
+		}
+		sc := c
+		sc.PresetTemp = step.Temp
+		if err := Spec.Check(&sc); err != nil {
+			return nil, nil, fmt.Errorf("sleep curve step %d: %v", i+1, err)
+		}
+		prev = step.Minutes
+	}
+	if err := Spec.Check(&c); err != nil {
+		return nil, nil, err
+	}
+	return &c, steps, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/wtks/A75C4269"
+	"strings"
+	"time"
+)
+
+// shortest pause between the frames of a sweep, to leave time to read the display
+const minCommissionPause = time.Second
+
+// runCommission emits a powered on frame of mode for each temperature from from to to
+// without connecting to the broker, pausing between them so that the operator can note
+// which ones the unit shows. Real frames are sent, so the confirm flag is required.
+func runCommission(app *gopi.AppInstance, mode string, from, to uint, pause time.Duration, confirm bool) error {
+	if !confirm {
+		return withExitCode(ExitConfig, errors.New("commission sends real IR frames, add -commission.confirm to run it"))
+	}
+	m, ok := modeValues[strings.ToLower(mode)]
+	if !ok {
+		return withExitCode(ExitConfig, fmt.Errorf("commission: invalid mode: %s (valid: %s)", mode, strings.Join(sortedNames(modeValues), ", ")))
+	}
+	// the encoder clamps anything outside its 4 bit range
+	if from < MinTemp || to > MaxTemp || from > to {
+		return withExitCode(ExitConfig, fmt.Errorf("commission: invalid range %d-%d (%d-%d)", from, to, MinTemp, MaxTemp))
+	}
+	if pause < minCommissionPause {
+		return withExitCode(ExitConfig, fmt.Errorf("commission: pause %v is shorter than %v", pause, minCommissionPause))
+	}
+
+	s := newService(app, nil)
+	defer s.notifying.Wait()
+
+	steps := int(to-from) + 1
+	var c *A75C4269.Controller
+	for t := from; t <= to; t++ {
+		if t > from {
+			time.Sleep(pause)
+		}
+		c = &A75C4269.Controller{
+			Power:         A75C4269.PowerOn,
+			Mode:          m,
+			PresetTemp:    t,
+			AirVolume:     A75C4269.AirVolumeAuto,
+			WindDirection: A75C4269.WindDirectionAuto,
+		}
+		// Spec.Check is skipped on purpose, the sweep is to find the range the unit accepts
+		if err := s.transmit(c.GetRawSignal(), 1); err != nil {
+			return err
+		}
+		app.Logger.Info("commission %d/%d: sent %s %d℃, check the display of the unit", int(t-from)+1, steps, modeName(m), t)
+	}
+
+	// the unit is left at the last frame
+	s.setState(c)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ConfirmTimeout is how long ActionPath waits for the outcome with wait=true by default
+const ConfirmTimeout = 30 * time.Second
+
+// results of ActionResult
+const (
+	// ActionResultSent is a command whose frame was transmitted
+	ActionResultSent = "sent"
+	// ActionResultNotSent is a command handled without a frame: ignored as a duplicate
+	// or jitter, coalesced, deferred, or an action which sends nothing by itself
+	ActionResultNotSent = "not_sent"
+)
+
+// ActionResult is the reply of ActionPath with wait=true once the command is handled
+type ActionResult struct {
+	Result string        `json:"result"`
+	State  *DecodedState `json:"state,omitempty"`
+}
+
+// confirmation is a command from ActionPath whose outcome is waited for. The loop
+// fills the outcome then closes done, it is read by the handler only after that.
+// runStdin records the outcome of its single command the same way.
+type confirmation struct {
+	payload []byte
+	done    chan struct{}
+
+	sent     bool
+	rejected error
+	failed   error
+	state    *DecodedState
+}
+
+// confirm handles the command of c like any command from HTTP and records its outcome,
+// the error of the emitters is returned as from handleAction
+func (s *service) confirm(c *confirmation) error {
+	s.confirming = c
+	err := s.handleAction(c.payload, sourceHTTP)
+	s.confirming = nil
+
+	c.failed = err
+	if c.sent && s.last != nil {
+		c.state = decodeState(s.last)
+	}
+	close(c.done)
+	return err
+}
+
+// handleConfirmed passes the command to confirmations and replies its outcome, or 504
+// when it is not handled within the timeout query or the request is cancelled
+func handleConfirmed(w http.ResponseWriter, r *http.Request, payload []byte, confirmations chan<- *confirmation) {
+	timeout := ConfirmTimeout
+	if v := r.URL.Query().Get("timeout"); len(v) > 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			httpError(w, http.StatusBadRequest, &fieldError{field: "timeout", message: "invalid timeout: " + v})
+			return
+		}
+		timeout = d
+	}
+
+	c := &confirmation{payload: payload, done: make(chan struct{})}
+	commandWaiting()
+	select {
+	case confirmations <- c:
+	case <-time.After(httpPassTimeout):
+		commandReceived()
+		httpError(w, http.StatusServiceUnavailable, errors.New("busy, try again later"))
+		return
+	}
+
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		httpError(w, http.StatusGatewayTimeout, fmt.Errorf("not handled within %v", timeout))
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	switch {
+	case c.failed != nil:
+		httpError(w, http.StatusBadGateway, c.failed)
+	case c.sent:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&ActionResult{Result: ActionResultSent, State: c.state})
+	case c.rejected != nil:
+		httpError(w, http.StatusUnprocessableEntity, c.rejected)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(&ActionResult{Result: ActionResultNotSent})
+	}
+}
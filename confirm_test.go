@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+// TestHandleConfirmed checks the status replied with wait=true for each outcome of
+// the command, handled by a loop receiving from confirmations like serve
+func TestHandleConfirmed(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		payload string
+		// setup prepares the service before the command
+		setup func(s *service)
+		// hang receives the command without ever handling it
+		hang   bool
+		status int
+		result string
+		code   string
+	}{
+		{"sent", "", `{"power":"on","mode":"cool","temp":26}`, nil, false,
+			http.StatusOK, ActionResultSent, ""},
+		{"not sent", "", `{"power":"on","mode":"cool","temp":27}`, func(s *service) {
+			s.last = &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+			MinTempChange = 2
+		}, false, http.StatusAccepted, ActionResultNotSent, ""},
+		{"rejected", "", `{"power":"on","fan":"turbo"}`, nil, false,
+			http.StatusUnprocessableEntity, "", APIErrorInvalidValue},
+		{"failed", "", `{"power":"on","mode":"cool","temp":26}`, func(s *service) {
+			s.emitters = []emitter{&testEmitter{name: EmitterFile, err: errors.New("disk full")}}
+		}, false, http.StatusBadGateway, "", APIErrorEmitFailed},
+		{"timeout", "&timeout=50ms", `{"power":"on","mode":"cool","temp":26}`, nil, true,
+			http.StatusGatewayTimeout, "", APIErrorTimeout},
+		{"invalid timeout", "&timeout=soon", `{"power":"on"}`, nil, false,
+			http.StatusBadRequest, "", APIErrorInvalidValue},
+	}
+	for _, tt := range tests {
+		func() {
+			_, restore := testConfig(t)
+			defer restore()
+			change := MinTempChange
+			defer func() { MinTempChange = change }()
+
+			s := newService(testApp(t), nil)
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+			confirmations := make(chan *confirmation)
+			release := make(chan struct{})
+			handled := make(chan struct{})
+			go func() {
+				defer close(handled)
+				select {
+				case c := <-confirmations:
+					commandReceived()
+					if tt.hang {
+						<-release
+						return
+					}
+					s.confirm(c)
+				case <-release:
+				}
+			}()
+
+			r := httptest.NewRequest(http.MethodPost, ActionPath+"?wait=true"+tt.query, strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+			handleActionRequest(w, r, nil, confirmations)
+			close(release)
+			<-handled
+
+			if w.Code != tt.status {
+				t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+				return
+			}
+			var v struct {
+				ActionResult
+				Error *APIError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				return
+			}
+			if len(tt.code) > 0 {
+				if v.Error == nil || v.Error.Code != tt.code {
+					t.Errorf("%s: error %+v, want %s", tt.name, v.Error, tt.code)
+				}
+				return
+			}
+			if v.Result != tt.result {
+				t.Errorf("%s: result %s, want %s", tt.name, v.Result, tt.result)
+			}
+			frames := emittedFrames(t)
+			switch tt.result {
+			case ActionResultSent:
+				if v.State == nil || v.State.Temp != 26 || frames == 0 {
+					t.Errorf("%s: state %+v with %d frames, want 26℃ emitted", tt.name, v.State, frames)
+				}
+			case ActionResultNotSent:
+				if v.State != nil || frames != 0 {
+					t.Errorf("%s: state %+v with %d frames, want nothing emitted", tt.name, v.State, frames)
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"time"
+)
+
+// values of STATE_CONVENTION
+const (
+	StateConventionNative      = "native"
+	StateConventionTasmota     = "tasmota"
+	StateConventionZigbee2MQTT = "zigbee2mqtt"
+)
+
+// TasmotaHVAC is the state in the IRHVAC object of a Tasmota SENSOR message
+type TasmotaHVAC struct {
+	Vendor   string `json:"Vendor"`
+	Power    string `json:"Power"`
+	Mode     string `json:"Mode"`
+	Celsius  string `json:"Celsius"`
+	Temp     uint   `json:"Temp"`
+	FanSpeed string `json:"FanSpeed"`
+	SwingV   string `json:"SwingV"`
+	Quiet    string `json:"Quiet"`
+	Turbo    string `json:"Turbo"`
+}
+
+// Zigbee2MQTTClimate is the state as a flat object of a Zigbee2MQTT thermostat
+type Zigbee2MQTTClimate struct {
+	State                   string   `json:"state"`
+	SystemMode              string   `json:"system_mode"`
+	CurrentHeatingSetpoint  uint     `json:"current_heating_setpoint"`
+	OccupiedCoolingSetpoint *uint    `json:"occupied_cooling_setpoint,omitempty"`
+	OccupiedHeatingSetpoint *uint    `json:"occupied_heating_setpoint,omitempty"`
+	FanMode                 string   `json:"fan_mode"`
+	SwingMode               string   `json:"swing_mode"`
+	LocalTemperature        *float64 `json:"local_temperature,omitempty"`
+	RunningState            string   `json:"running_state"`
+}
+
+// conventionTopic is where the state formatted with StateConvention is published
+func conventionTopic() string {
+	return PubTopic + "/" + StateConvention
+}
+
+// conventionState formats c according to StateConvention, ambient is the latest
+// reading of AmbientTopic if any
+func conventionState(c *A75C4269.Controller, ambient *float64) ([]byte, error) {
+	switch StateConvention {
+	case StateConventionTasmota:
+		return json.Marshal(&struct {
+			Time   string      `json:"Time"`
+			IRHVAC TasmotaHVAC `json:"IRHVAC"`
+		}{
+			Time:   time.Now().In(Location).Format("2006-01-02T15:04:05"),
+			IRHVAC: tasmotaState(c),
+		})
+	case StateConventionZigbee2MQTT:
+		return json.Marshal(zigbee2MQTTState(c, ambient))
+	default:
+		return nil, fmt.Errorf("unknown state convention: %s", StateConvention)
+	}
+}
+
+func tasmotaState(c *A75C4269.Controller) TasmotaHVAC {
+	d := decodeState(c)
+	h := TasmotaHVAC{
+		Vendor:   "A75C4269",
+		Power:    "Off",
+		Mode:     "Off",
+		Celsius:  "On",
+		Temp:     d.Temp,
+		FanSpeed: "Auto",
+		SwingV:   "Auto",
+		Quiet:    "Off",
+		Turbo:    "Off",
+	}
+	if d.Power == "on" {
+		h.Power = "On"
+		h.Mode = map[string]string{"cool": "Cool", "heat": "Heat", "dry": "Dry"}[d.Mode]
+	}
+	switch c.AirVolume {
+	case A75C4269.AirVolumeStill:
+		h.FanSpeed, h.Quiet = "Min", "On"
+	case A75C4269.AirVolume1:
+		h.FanSpeed = "Min"
+	case A75C4269.AirVolume2:
+		h.FanSpeed = "Low"
+	case A75C4269.AirVolume3:
+		h.FanSpeed = "Medium"
+	case A75C4269.AirVolume4:
+		h.FanSpeed = "High"
+	case A75C4269.AirVolumePowerful:
+		h.FanSpeed, h.Turbo = "Max", "On"
+	}
+	switch c.WindDirection {
+	case A75C4269.WindDirection1:
+		h.SwingV = "Highest"
+	case A75C4269.WindDirection2:
+		h.SwingV = "High"
+	case A75C4269.WindDirection3:
+		h.SwingV = "Middle"
+	case A75C4269.WindDirection4:
+		h.SwingV = "Low"
+	case A75C4269.WindDirection5:
+		h.SwingV = "Lowest"
+	}
+	return h
+}
+
+func zigbee2MQTTState(c *A75C4269.Controller, ambient *float64) *Zigbee2MQTTClimate {
+	d := decodeState(c)
+	z := &Zigbee2MQTTClimate{
+		State:                  "OFF",
+		SystemMode:             "off",
+		CurrentHeatingSetpoint: d.Temp,
+		FanMode:                "auto",
+		SwingMode:              "on",
+		LocalTemperature:       ambient,
+		RunningState:           "idle",
+	}
+	if d.Power == "on" {
+		z.State = "ON"
+		z.SystemMode = d.Mode
+		switch d.Mode {
+		case "cool":
+			z.OccupiedCoolingSetpoint = &d.Temp
+			z.RunningState = "cool"
+		case "heat":
+			z.OccupiedHeatingSetpoint = &d.Temp
+			z.RunningState = "heat"
+		case "dry":
+			z.RunningState = "fan_only"
+		}
+	}
+	switch c.AirVolume {
+	case A75C4269.AirVolumeStill, A75C4269.AirVolume1:
+		z.FanMode = "low"
+	case A75C4269.AirVolume2, A75C4269.AirVolume3:
+		z.FanMode = "medium"
+	case A75C4269.AirVolume4, A75C4269.AirVolumePowerful:
+		z.FanMode = "high"
+	}
+	if c.WindDirection != A75C4269.WindDirectionAuto {
+		z.SwingMode = "off"
+	}
+	return z
+}
+
+// publishConventionState publishes c formatted with StateConvention on conventionTopic,
+// it does nothing with the native convention
+func (s *service) publishConventionState(c *A75C4269.Controller) error {
+	if StateConvention == StateConventionNative {
+		return nil
+	}
+	payload, err := conventionState(c, s.ambient)
+	if err != nil {
+		return err
+	}
+	return s.publish(conventionTopic(), 1, true, string(payload))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestConventionState(t *testing.T) {
+	uintp := func(v uint) *uint { return &v }
+	ambient := 27.5
+	tests := []struct {
+		name    string
+		c       A75C4269.Controller
+		ambient *float64
+		tasmota TasmotaHVAC
+		z2m     Zigbee2MQTTClimate
+	}{
+		{"cool", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume2, WindDirection: A75C4269.WindDirection1}, &ambient,
+			TasmotaHVAC{"A75C4269", "On", "Cool", "On", 26, "Low", "Highest", "Off", "Off"},
+			Zigbee2MQTTClimate{"ON", "cool", 26, uintp(26), nil, "medium", "off", &ambient, "cool"}},
+		{"heat powerful", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 22, AirVolume: A75C4269.AirVolumePowerful}, nil,
+			TasmotaHVAC{"A75C4269", "On", "Heat", "On", 22, "Max", "Auto", "Off", "On"},
+			Zigbee2MQTTClimate{"ON", "heat", 22, nil, uintp(22), "high", "on", nil, "heat"}},
+		{"dry still", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeDehumidifier, PresetTemp: 24, AirVolume: A75C4269.AirVolumeStill, WindDirection: A75C4269.WindDirection5}, nil,
+			TasmotaHVAC{"A75C4269", "On", "Dry", "On", 24, "Min", "Lowest", "On", "Off"},
+			Zigbee2MQTTClimate{"ON", "dry", 24, nil, nil, "low", "off", nil, "fan_only"}},
+		{"off", A75C4269.Controller{Power: A75C4269.PowerOff, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume4}, &ambient,
+			TasmotaHVAC{"A75C4269", "Off", "Off", "On", 26, "High", "Auto", "Off", "Off"},
+			Zigbee2MQTTClimate{"OFF", "off", 26, nil, nil, "high", "on", &ambient, "idle"}},
+	}
+	for _, tt := range tests {
+		if got := tasmotaState(&tt.c); got != tt.tasmota {
+			t.Errorf("%s: tasmota %+v, want %+v", tt.name, got, tt.tasmota)
+		}
+		if got := zigbee2MQTTState(&tt.c, tt.ambient); !reflect.DeepEqual(*got, tt.z2m) {
+			t.Errorf("%s: zigbee2mqtt %+v, want %+v", tt.name, *got, tt.z2m)
+		}
+	}
+}
+
+// TestPublishConventionState checks the state is published retained on the topic of
+// StateConvention, in the form of the convention
+func TestPublishConventionState(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+
+	StateConvention = StateConventionTasmota
+	s, msgs, close := testPublishing(t, broker, PubTopic+"/+")
+	defer close()
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26,"fan":"3"}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, msgs, "tasmota state")
+	if msg.Topic() != PubTopic+"/tasmota" {
+		t.Errorf("published on %s", msg.Topic())
+	}
+	late, retained := testClient(t, broker, "late", PubTopic+"/tasmota")
+	defer late.Disconnect(250)
+	if r := receive(t, retained, "retained tasmota state"); !r.Retained() || string(r.Payload()) != string(msg.Payload()) {
+		t.Errorf("retained %v: %s", r.Retained(), r.Payload())
+	}
+	var sensor struct {
+		Time   string
+		IRHVAC TasmotaHVAC
+	}
+	if err := json.Unmarshal(msg.Payload(), &sensor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.ParseInLocation("2006-01-02T15:04:05", sensor.Time, Location); err != nil {
+		t.Errorf("time: %v", err)
+	}
+	if want := (TasmotaHVAC{"A75C4269", "On", "Cool", "On", 26, "Medium", "Auto", "Off", "Off"}); sensor.IRHVAC != want {
+		t.Errorf("got %+v, want %+v", sensor.IRHVAC, want)
+	}
+
+	StateConvention = StateConventionZigbee2MQTT
+	ambient := 28.0
+	s.ambient = &ambient
+	if err := s.handleAction([]byte(`{"power":"off"}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	msg = receive(t, msgs, "zigbee2mqtt state")
+	if msg.Topic() != PubTopic+"/zigbee2mqtt" {
+		t.Errorf("published on %s", msg.Topic())
+	}
+	var z map[string]interface{}
+	if err := json.Unmarshal(msg.Payload(), &z); err != nil {
+		t.Fatal(err)
+	}
+	if z["state"] != "OFF" || z["system_mode"] != "off" || z["local_temperature"] != 28.0 {
+		t.Errorf("got %s", msg.Payload())
+	}
+	if _, ok := z["occupied_cooling_setpoint"]; ok {
+		t.Errorf("cooling setpoint while off: %s", msg.Payload())
+	}
+
+	StateConvention = StateConventionNative
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":25}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		t.Errorf("published %s on %s with the native convention", msg.Payload(), msg.Topic())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/wtks/A75C4269"
+	"strconv"
+)
+
+// DecodedState is the human readable form of a state
+type DecodedState struct {
+	Power string `json:"power"`
+	Mode  string `json:"mode"`
+	Temp  uint   `json:"temp"`
+	Fan   string `json:"fan"`
+	Swing string `json:"swing"`
+}
+
+func decodeState(c *A75C4269.Controller) *DecodedState {
+	return &DecodedState{
+		Power: powerName(c.Power),
+		Mode:  modeName(c.Mode),
+		Temp:  c.PresetTemp,
+		Fan:   airVolumeName(c.AirVolume),
+		Swing: windDirectionName(c.WindDirection),
+	}
+}
+
+func powerName(power byte) string {
+	switch power {
+	case A75C4269.PowerOn, A75C4269.PowerOnAndOffTimer:
+		return "on"
+	default:
+		return "off"
+	}
+}
+
+func modeName(mode byte) string {
+	switch mode {
+	case A75C4269.ModeCooler:
+		return "cool"
+	case A75C4269.ModeHeater:
+		return "heat"
+	case A75C4269.ModeDehumidifier:
+		return "dry"
+	default:
+		return "unknown"
+	}
+}
+
+func airVolumeName(volume byte) string {
+	switch volume {
+	case A75C4269.AirVolumeAuto:
+		return "auto"
+	case A75C4269.AirVolumeStill:
+		return "still"
+	case A75C4269.AirVolumePowerful:
+		return "powerful"
+	default:
+		return strconv.FormatInt(int64(volume-1), 10)
+	}
+}
+
+func windDirectionName(direction byte) string {
+	switch direction {
+	case A75C4269.WindDirectionAuto:
+		return "auto"
+	default:
+		return strconv.FormatInt(int64(direction), 10)
+	}
+}
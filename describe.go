@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Description is how a state is notified, returned by DescribePath and DescribeTopic
+// without sending anything
+type Description struct {
+	Message string        `json:"message"`
+	State   *DecodedState `json:"state"`
+}
+
+// describe returns the Description of the plain state in payload, resolved and
+// checked as RawPath does
+func describe(payload []byte) (*Description, error) {
+	cmd := Command{}
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, err
+	}
+	if len(cmd.Raw) > 0 || cmd.Boost != nil {
+		return nil, errors.New("only plain states can be described")
+	}
+	c, err := cmd.Resolve(nil)
+	if err == nil {
+		err = Spec.Check(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Description{Message: makeMessage(c), State: decodeState(c)}, nil
+}
+
+// handleDescribe returns the Description of the command in the request body
+func handleDescribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	d, err := describe(payload)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// publishDescription publishes the Description of the command of a message on
+// DescribeTopic, or the error, on DescribeResultTopic
+func (s *service) publishDescription(payload []byte) {
+	var v interface{}
+	d, err := describe(payload)
+	if err != nil {
+		v = &ErrorMessage{Error: err.Error()}
+	} else {
+		v = d
+	}
+	b, _ := json.Marshal(v)
+	if err := s.publish(DescribeResultTopic, 0, false, string(b)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestHandleDescribe(t *testing.T) {
+	cool := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume2}
+	tests := []struct {
+		name, method, body string
+		status             int
+		want               *A75C4269.Controller
+		err                string
+	}{
+		{"state", http.MethodPost, `{"power":"on","mode":"cool","temp":26,"fan":"2"}`, http.StatusOK, cool, ""},
+		{"out of range", http.MethodPost, `{"power":"on","mode":"cool","temp":40}`, http.StatusBadRequest, nil, "temperature out of range"},
+		{"raw", http.MethodPost, `{"raw":[9000,4500,560]}`, http.StatusBadRequest, nil, "only plain states can be described"},
+		{"invalid", http.MethodPost, `{"fan":"turbo"}`, http.StatusBadRequest, nil, "invalid fan"},
+		{"method", http.MethodGet, "", http.StatusMethodNotAllowed, nil, "method not allowed"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleDescribe(w, httptest.NewRequest(tt.method, DescribePath, strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.want == nil {
+			if !strings.Contains(w.Body.String(), tt.err) {
+				t.Errorf("%s: got %s, want %q", tt.name, w.Body, tt.err)
+			}
+			continue
+		}
+		var d Description
+		if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Message != makeMessage(tt.want) {
+			t.Errorf("%s: message %q, want %q", tt.name, d.Message, makeMessage(tt.want))
+		}
+		if d.State == nil || *d.State != *decodeState(tt.want) {
+			t.Errorf("%s: state %+v, want %+v", tt.name, d.State, decodeState(tt.want))
+		}
+	}
+}
+
+// TestPublishDescription checks a request on DescribeTopic is answered on
+// DescribeResultTopic without sending anything
+func TestPublishDescription(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+
+	s, results, close := testPublishing(t, broker, DescribeResultTopic)
+	defer close()
+	s.publishDescription([]byte(`{"power":"on","mode":"heat","temp":22}`))
+	var d Description
+	if err := json.Unmarshal(receive(t, results, "description").Payload(), &d); err != nil {
+		t.Fatal(err)
+	}
+	heat := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 22}
+	if d.Message != makeMessage(heat) || d.State == nil || d.State.Temp != 22 {
+		t.Errorf("got %+v, want the description of %+v", d, heat)
+	}
+
+	s.publishDescription([]byte(`{"power":"on","mode":"heat","temp":12}`))
+	var e ErrorMessage
+	if err := json.Unmarshal(receive(t, results, "error").Payload(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(e.Error, "temperature out of range") {
+		t.Errorf("error %q", e.Error)
+	}
+
+	if n := emittedFrames(t); n != 0 || s.last != nil {
+		t.Errorf("%d frames emitted and state %+v, want nothing sent", n, s.last)
+	}
+}
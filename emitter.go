@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// names of the emitters accepted in EMITTERS
+const (
+	EmitterLIRC   = "lirc"
+	EmitterMQTT   = "mqtt"
+	EmitterFile   = "file"
+	EmitterSerial = "serial"
+)
+
+// errNoHardware is returned for LIRC and GPIO in a build with the nohw tag
+var errNoHardware = errors.New("not available in a build with the nohw tag")
+
+// emitter sends raw timings to the aircon
+type emitter interface {
+	Name() string
+	Send(raw []uint32) error
+}
+
+// lircEmitter sends through the local LIRC devices one after another, it succeeds
+// when any of them does
+type lircEmitter struct {
+	log     gopi.Logger
+	devices []string
+	lircs   []gopi.LIRC
+}
+
+func (e *lircEmitter) Name() string {
+	return EmitterLIRC
+}
+
+func (e *lircEmitter) Send(raw []uint32) error {
+	if len(e.lircs) == 1 {
+		return e.lircs[0].PulseSend(raw)
+	}
+
+	var failed []string
+	for i, l := range e.lircs {
+		if err := l.PulseSend(raw); err != nil {
+			e.log.Warn("lirc emitter: %s failed: %v", e.devices[i], err)
+			failed = append(failed, e.devices[i])
+			continue
+		}
+		e.log.Debug("lirc emitter: sent on %s", e.devices[i])
+	}
+	if len(failed) == len(e.lircs) {
+		return fmt.Errorf("lirc emitter: all devices failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// newLIRCEmitter sends through app.LIRC, opened on LIRCDevice, and the other
+// LIRCDevices, which are skipped when they cannot be opened
+func newLIRCEmitter(app *gopi.AppInstance) *lircEmitter {
+	e := &lircEmitter{log: app.Logger, devices: []string{LIRCDevice}, lircs: []gopi.LIRC{app.LIRC}}
+	for _, device := range LIRCDevices {
+		if device == LIRCDevice {
+			continue
+		}
+		l, err := openLIRC(app, device)
+		if err != nil {
+			app.Logger.Error("lirc emitter: %s skipped: %v", device, err)
+			continue
+		}
+		e.devices = append(e.devices, device)
+		e.lircs = append(e.lircs, l)
+	}
+	return e
+}
+
+// mqttEmitter publishes the timings as a JSON array to a remote emitter such as ESPHome
+type mqttEmitter struct {
+	client mqtt.Client
+	topic  string
+}
+
+func (e *mqttEmitter) Name() string {
+	return EmitterMQTT
+}
+
+func (e *mqttEmitter) Send(raw []uint32) error {
+	payload, _ := json.Marshal(raw)
+	return waitToken(e.client.Publish(e.topic, 1, false, payload), "mqtt emitter: publish "+e.topic)
+}
+
+// fileEmitter writes the timings in the mode2 format of LIRC, each frame preceded by a
+// comment with the time and the decoded frame bytes, for offline captures
+type fileEmitter struct {
+	w io.Writer
+}
+
+func (e *fileEmitter) Name() string {
+	return EmitterFile
+}
+
+func (e *fileEmitter) Send(raw []uint32) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s", time.Now().In(Location).Format(time.RFC3339))
+	if frames, err := decodeFrames(raw); err == nil {
+		for _, f := range frames {
+			fmt.Fprintf(&b, " [% X]", f)
+		}
+	}
+	b.WriteString("\n")
+	for i, t := range raw {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "pulse %d\n", t)
+		} else {
+			fmt.Fprintf(&b, "space %d\n", t)
+		}
+	}
+	_, err := e.w.Write(b.Bytes())
+	return err
+}
+
+// openEmitterFile opens EmitterFilePath for the file emitter, "-" is stdout
+func openEmitterFile() (io.Writer, error) {
+	if EmitterFilePath == "-" {
+		return os.Stdout, nil
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if EmitterFileAppend {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	return os.OpenFile(EmitterFilePath, flag, 0644)
+}
+
+// usesEmitter tells whether name is configured in Emitters
+func usesEmitter(name string) bool {
+	for _, e := range Emitters {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newEmitters returns the emitters in the order of Emitters, the mqtt emitter is
+// skipped when running without the broker
+func newEmitters(app *gopi.AppInstance, client mqtt.Client) []emitter {
+	var emitters []emitter
+	for _, name := range Emitters {
+		switch name {
+		case EmitterLIRC:
+			emitters = append(emitters, newLIRCEmitter(app))
+		case EmitterMQTT:
+			if client == nil {
+				app.Logger.Warn("mqtt emitter skipped without the broker")
+				continue
+			}
+			emitters = append(emitters, &mqttEmitter{client: client, topic: EmitterMQTTTopic})
+		case EmitterFile:
+			w, err := openEmitterFile()
+			if err != nil {
+				app.Logger.Error("file emitter skipped: %v", err)
+				continue
+			}
+			emitters = append(emitters, &fileEmitter{w: w})
+		case EmitterSerial:
+			e := &serialEmitter{
+				open: func() (io.ReadWriteCloser, error) {
+					return openSerial(EmitterSerialDevice, EmitterSerialBaud)
+				},
+				timeout: EmitterSerialTimeout,
+			}
+			// kept when the port cannot be opened yet, it is opened again on each frame
+			if port, err := e.open(); err != nil {
+				app.Logger.Error("serial emitter: %v", err)
+			} else {
+				e.port = port
+			}
+			emitters = append(emitters, e)
+		}
+	}
+	return emitters
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/djthorpe/gopi"
+	"github.com/wtks/A75C4269"
+)
+
+// testEmitter counts the frames sent, failing all of them with err, and calls onSend
+// if set after each of them
+type testEmitter struct {
+	name   string
+	err    error
+	sends  int
+	onSend func()
+}
+
+func (e *testEmitter) Name() string {
+	return e.name
+}
+
+func (e *testEmitter) Send(raw []uint32) error {
+	e.sends++
+	if e.onSend != nil {
+		e.onSend()
+	}
+	return e.err
+}
+
+// testLIRC is a LIRC device which only counts the frames sent, failing them with err
+type testLIRC struct {
+	gopi.LIRC
+	err   error
+	sends int
+}
+
+func (l *testLIRC) PulseSend(values []uint32) error {
+	l.sends++
+	return l.err
+}
+
+// TestLIRCEmitterDevices checks a frame is sent on every device, and fails only when
+// all of them fail
+func TestLIRCEmitterDevices(t *testing.T) {
+	failed := errors.New("device gone")
+	tests := []struct {
+		name string
+		errs []error
+		err  bool
+	}{
+		{"single", []error{nil}, false},
+		{"single failed", []error{failed}, true},
+		{"all sent", []error{nil, nil, nil}, false},
+		{"one failed", []error{nil, failed, nil}, false},
+		{"all failed", []error{failed, failed}, true},
+	}
+	for _, tt := range tests {
+		e := &lircEmitter{log: testApp(t).Logger}
+		var lircs []*testLIRC
+		for i, err := range tt.errs {
+			l := &testLIRC{err: err}
+			lircs = append(lircs, l)
+			e.devices = append(e.devices, fmt.Sprintf("/dev/lirc%d", i))
+			e.lircs = append(e.lircs, l)
+		}
+		if err := e.Send([]uint32{560}); (err != nil) != tt.err {
+			t.Errorf("%s: got %v, want an error %v", tt.name, err, tt.err)
+		}
+		for i, l := range lircs {
+			if l.sends != 1 {
+				t.Errorf("%s: sent %d times on %s, want once", tt.name, l.sends, e.devices[i])
+			}
+		}
+	}
+}
+
+func TestFileEmitter(t *testing.T) {
+	c := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	raw := c.GetRawSignal()
+	var b bytes.Buffer
+	if err := (&fileEmitter{w: &b}).Send(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 1+len(raw) {
+		t.Fatalf("%d lines, want a comment and %d timings", len(lines), len(raw))
+	}
+	if want := fmt.Sprintf(" [% X]", c.GetSignalBytes()); !strings.HasPrefix(lines[0], "# ") || !strings.Contains(lines[0], want) {
+		t.Errorf("comment %q, want the time and%s", lines[0], want)
+	}
+	for i, line := range lines[1:] {
+		want := fmt.Sprintf("pulse %d", raw[i])
+		if i%2 == 1 {
+			want = fmt.Sprintf("space %d", raw[i])
+		}
+		if line != want {
+			t.Errorf("line %d: %q, want %q", i+2, line, want)
+			break
+		}
+	}
+}
+
+// TestOpenEmitterFile checks the file is emptied at startup unless EmitterFileAppend
+func TestOpenEmitterFile(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	appendFile := EmitterFileAppend
+	defer func() { EmitterFileAppend = appendFile }()
+	raw := (&A75C4269.Controller{Power: A75C4269.PowerOff}).GetRawSignal()
+
+	tests := []struct {
+		append bool
+		frames int
+	}{
+		{false, 1},
+		{false, 1},
+		{true, 2},
+		{true, 3},
+	}
+	for i, tt := range tests {
+		EmitterFileAppend = tt.append
+		w, err := openEmitterFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&fileEmitter{w: w}).Send(raw); err != nil {
+			t.Fatal(err)
+		}
+		w.(io.Closer).Close()
+		if n := emittedFrames(t); n != tt.frames {
+			b, _ := ioutil.ReadFile(EmitterFilePath)
+			t.Errorf("start %d, append %v: %d frames, want %d\n%s", i+1, tt.append, n, tt.frames, b)
+		}
+	}
+}
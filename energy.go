@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActionResetEnergy resets the energy estimate
+const ActionResetEnergy = "reset_energy"
+
+// how often the energy estimate is published while the service runs
+const energyPublishInterval = 15 * time.Minute
+
+// EnergyPeriod is the estimated consumption over a period from Start
+type EnergyPeriod struct {
+	Start        time.Time `json:"start"`
+	KWh          float64   `json:"kwh"`
+	RuntimeHours float64   `json:"runtime_hours"`
+}
+
+// EnergyEstimate is the consumption estimated from ENERGY_WATTS and the time spent in
+// each state, not a measurement
+type EnergyEstimate struct {
+	// Estimated is always true, for the readers of the payload
+	Estimated bool `json:"estimated"`
+	// Watts is the estimate of the current state
+	Watts float64 `json:"watts"`
+	// Total is since the last reset
+	Total EnergyPeriod `json:"total"`
+	// Week is since Monday 00:00 in Location, LastWeek the whole week before
+	Week     EnergyPeriod  `json:"week"`
+	LastWeek *EnergyPeriod `json:"last_week,omitempty"`
+}
+
+// parseEnergyWatts parses ENERGY_WATTS, a list of mode=watts or mode/fan=watts
+func parseEnergyWatts(v string) (map[string]float64, error) {
+	watts := make(map[string]float64)
+	for _, s := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(s), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid watts: %s", s)
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		keys := strings.SplitN(key, "/", 2)
+		if _, ok := modeValues[keys[0]]; !ok {
+			return nil, fmt.Errorf("invalid mode: %s (cool, heat, dry)", keys[0])
+		}
+		if len(keys) == 2 {
+			if _, ok := airVolumeValues[keys[1]]; !ok {
+				return nil, fmt.Errorf("invalid fan: %s", keys[1])
+			}
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid watts: %s", kv[1])
+		}
+		watts[key] = w
+	}
+	return watts, nil
+}
+
+// energyWatts returns the estimated power of c, 0 when it is off or unknown. The
+// watts of mode/fan are taken before those of the mode.
+func energyWatts(c *A75C4269.Controller) float64 {
+	if c == nil || powerName(c.Power) == "off" {
+		return 0
+	}
+	mode := modeName(c.Mode)
+	if w, ok := EnergyWatts[mode+"/"+airVolumeName(c.AirVolume)]; ok {
+		return w
+	}
+	return EnergyWatts[mode]
+}
+
+// weekStart returns Monday 00:00 in Location of the week of t
+func weekStart(t time.Time) time.Time {
+	t = t.In(Location)
+	days := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, Location)
+}
+
+// energy accumulates the estimate, updated by the loop and read by the HTTP API
+type energy struct {
+	mu       sync.Mutex
+	path     string
+	estimate EnergyEstimate
+	// when the estimate was last accumulated
+	at time.Time
+}
+
+// energyPath is where the estimate is kept next to StateFile
+func energyPath() string {
+	if len(StateFile) == 0 {
+		return ""
+	}
+	return StateFile + ".energy"
+}
+
+// newEnergy loads the estimate from path, or starts a new one at now. The time the
+// service was stopped is not counted.
+func newEnergy(path string, now time.Time) (*energy, error) {
+	e := &energy{path: path, at: now}
+	e.start(now)
+	if len(path) == 0 {
+		return e, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	} else if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(b, &e.estimate); err != nil {
+		e.start(now)
+		return e, err
+	}
+	e.estimate.Estimated = true
+	return e, nil
+}
+
+// start clears the estimate
+func (e *energy) start(now time.Time) {
+	e.estimate = EnergyEstimate{
+		Estimated: true,
+		Watts:     e.estimate.Watts,
+		Total:     EnergyPeriod{Start: now},
+		Week:      EnergyPeriod{Start: weekStart(now)},
+	}
+}
+
+// accumulate adds the consumption at the current watts up to now, rolling the week
+// over on each Monday on the way. It is called with the mutex held.
+func (e *energy) accumulate(now time.Time) {
+	if now.Before(e.at) {
+		// the clock went back
+		e.at = now
+		return
+	}
+	for {
+		next := weekStart(e.estimate.Week.Start.AddDate(0, 0, 8))
+		until := now
+		if next.Before(until) {
+			until = next
+		}
+		if until.After(e.at) {
+			hours := until.Sub(e.at).Hours()
+			for _, p := range []*EnergyPeriod{&e.estimate.Total, &e.estimate.Week} {
+				p.KWh += e.estimate.Watts * hours / 1000
+				if e.estimate.Watts > 0 {
+					p.RuntimeHours += hours
+				}
+			}
+			e.at = until
+		}
+		if now.Before(next) {
+			return
+		}
+		last := e.estimate.Week
+		e.estimate.LastWeek = &last
+		e.estimate.Week = EnergyPeriod{Start: next}
+	}
+}
+
+// update accumulates up to now and continues at the watts of c
+func (e *energy) update(now time.Time, c *A75C4269.Controller) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.accumulate(now)
+	e.estimate.Watts = energyWatts(c)
+}
+
+// reset clears the estimate, the current watts are kept
+func (e *energy) reset(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.at = now
+	e.start(now)
+}
+
+// snapshot returns the estimate accumulated up to now
+func (e *energy) snapshot(now time.Time) EnergyEstimate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.accumulate(now)
+	s := e.estimate
+	if s.LastWeek != nil {
+		last := *s.LastWeek
+		s.LastWeek = &last
+	}
+	return s
+}
+
+func (e *energy) save() error {
+	if len(e.path) == 0 {
+		return nil
+	}
+	e.mu.Lock()
+	b, _ := json.Marshal(&e.estimate)
+	e.mu.Unlock()
+	return writeFileAtomic(e.path, b)
+}
+
+// updateEnergy accounts the time spent in the previous state before c is set, c is
+// nil when the state becomes unknown
+func (s *service) updateEnergy(c *A75C4269.Controller) {
+	if s.energy == nil {
+		return
+	}
+	s.energy.update(time.Now(), c)
+	s.publishEnergy()
+}
+
+// resetEnergy clears the estimate for ActionResetEnergy
+func (s *service) resetEnergy() {
+	if s.energy == nil {
+		s.fail(errors.New("energy estimate is disabled: set ENERGY_WATTS"))
+		return
+	}
+	s.energy.reset(time.Now())
+	s.app.Logger.Info("energy estimate reset")
+	s.publishEnergy()
+}
+
+// publishEnergy persists the estimate and publishes it on EnergyTopic, then arms the
+// next publish
+func (s *service) publishEnergy() {
+	v := s.energy.snapshot(time.Now())
+	if err := s.energy.save(); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	payload, _ := json.Marshal(&v)
+	if err := s.publish(EnergyTopic, 0, true, string(payload)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	s.energyTick = time.After(energyPublishInterval)
+}
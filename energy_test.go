@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestParseEnergyWatts(t *testing.T) {
+	tests := []struct {
+		v    string
+		want map[string]float64
+		err  bool
+	}{
+		{"cool=600, heat=800,COOL/Powerful=1200", map[string]float64{"cool": 600, "heat": 800, "cool/powerful": 1200}, false},
+		{"dry=300.5", map[string]float64{"dry": 300.5}, false},
+		{"fan=100", nil, true},
+		{"cool/turbo=100", nil, true},
+		{"cool=-1", nil, true},
+		{"cool", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseEnergyWatts(tt.v)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.v, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.v, got, tt.want)
+		}
+		for k, w := range tt.want {
+			if got[k] != w {
+				t.Errorf("%q: %s got %v, want %v", tt.v, k, got[k], w)
+			}
+		}
+	}
+}
+
+// TestEnergyAccumulate follows the estimate across state changes and the start of a
+// week, the watts of mode/fan taking precedence over those of the mode
+func TestEnergyAccumulate(t *testing.T) {
+	watts, location := EnergyWatts, Location
+	EnergyWatts, Location = map[string]float64{"cool": 500, "cool/powerful": 1000}, time.UTC
+	defer func() { EnergyWatts, Location = watts, location }()
+
+	cool := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	powerful := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolumePowerful}
+	off := &A75C4269.Controller{Power: A75C4269.PowerOff, Mode: A75C4269.ModeCooler}
+
+	// Sunday 22:00, the week turns on Monday 2024-07-01 00:00
+	start := time.Date(2024, 6, 30, 22, 0, 0, 0, time.UTC)
+	monday := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	e, err := newEnergy("", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.update(start, cool)
+	e.update(start.Add(time.Hour), powerful)
+	e.update(start.Add(3*time.Hour), off)
+
+	v := e.snapshot(start.Add(5 * time.Hour))
+	if !v.Estimated || v.Watts != 0 {
+		t.Errorf("estimated %v at %vW, want an estimate at 0W when off", v.Estimated, v.Watts)
+	}
+	checkPeriod(t, "total", v.Total, start, 2.5, 3)
+	checkPeriod(t, "week", v.Week, monday, 1, 1)
+	if v.LastWeek == nil {
+		t.Fatal("no last week")
+	}
+	checkPeriod(t, "last week", *v.LastWeek, weekStart(start), 1.5, 2)
+
+	// the clock going back adds nothing
+	e.update(start.Add(4*time.Hour), cool)
+	v = e.snapshot(start.Add(4 * time.Hour))
+	checkPeriod(t, "total after the clock went back", v.Total, start, 2.5, 3)
+
+	e.reset(start.Add(6 * time.Hour))
+	v = e.snapshot(start.Add(7 * time.Hour))
+	if v.Watts != 500 {
+		t.Errorf("%vW after the reset, want the current 500W", v.Watts)
+	}
+	checkPeriod(t, "total after the reset", v.Total, start.Add(6*time.Hour), 0.5, 1)
+}
+
+// TestEnergyRestart checks the estimate is kept across a restart, without counting the
+// time the service was stopped
+func TestEnergyRestart(t *testing.T) {
+	watts, location := EnergyWatts, Location
+	EnergyWatts, Location = map[string]float64{"heat": 800}, time.UTC
+	defer func() { EnergyWatts, Location = watts, location }()
+	dir, err := ioutil.TempDir("", "energy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json.energy")
+
+	start := time.Date(2024, 7, 2, 8, 0, 0, 0, time.UTC)
+	e, err := newEnergy(path, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.update(start, &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 22})
+	// a snapshot accumulates up to its time
+	e.snapshot(start.Add(30 * time.Minute))
+	if err := e.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restart := start.Add(10 * time.Hour)
+	e, err = newEnergy(path, restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := e.snapshot(restart)
+	checkPeriod(t, "total", v.Total, start, 0.4, 0.5)
+	checkPeriod(t, "week", v.Week, weekStart(start), 0.4, 0.5)
+}
+
+func checkPeriod(t *testing.T, name string, p EnergyPeriod, start time.Time, kWh, hours float64) {
+	if !p.Start.Equal(start) {
+		t.Errorf("%s: start %v, want %v", name, p.Start, start)
+	}
+	if math.Abs(p.KWh-kWh) > 1e-9 || math.Abs(p.RuntimeHours-hours) > 1e-9 {
+		t.Errorf("%s: %vkWh in %vh, want %vkWh in %vh", name, p.KWh, p.RuntimeHours, kWh, hours)
+	}
+}
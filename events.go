@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"time"
+)
+
+// types of the diagnostic events published on EventTopic
+const (
+	EventReconnected      = "reconnected"
+	EventScheduleFired    = "schedule_fired"
+	EventDuplicateDropped = "duplicate_dropped"
+	EventJitterIgnored    = "jitter_ignored"
+	EventCoalesced        = "coalesced"
+	EventEmitterFailed    = "emitter_failed"
+	EventRepeatsSkipped   = "repeats_skipped"
+	EventTempSnapped      = "temp_snapped"
+	EventSanityWarning    = "sanity_warning"
+)
+
+// DiagnosticEvent is a message of EventTopic, something the service did besides
+// sending states which an external timeline may want to show
+type DiagnosticEvent struct {
+	Type    string                 `json:"type"`
+	Time    time.Time              `json:"time"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// publishEvent publishes an event of typ on EventTopic without waiting for the broker,
+// it does nothing unless EventTopic is set
+func publishEvent(client mqtt.Client, typ string, details map[string]interface{}) {
+	if len(EventTopic) == 0 || client == nil {
+		return
+	}
+	payload, _ := json.Marshal(&DiagnosticEvent{Type: typ, Time: time.Now(), Details: details})
+	token := client.Publish(EventTopic, 0, false, payload)
+	go func() {
+		if err := waitToken(token, "event "+typ); err != nil {
+			log.Printf("%v", err)
+		}
+	}()
+}
+
+// event publishes an event of typ with the client of s
+func (s *service) event(typ string, details map[string]interface{}) {
+	publishEvent(s.client, typ, details)
+}
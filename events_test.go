@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestPublishEvent checks that a snapped temperature is reported on EventTopic, and
+// that nothing is published there once EventTopic is unset
+func TestPublishEvent(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+	topic := EventTopic
+	EventTopic = "aircon/test/events"
+	defer func() { EventTopic = topic }()
+
+	s, events, close := testPublishing(t, broker, EventTopic)
+	defer close()
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26.4}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	var e DiagnosticEvent
+	if err := json.Unmarshal(receive(t, events, "event").Payload(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventTempSnapped {
+		t.Errorf("type %s, want %s", e.Type, EventTempSnapped)
+	}
+	if e.Time.IsZero() {
+		t.Error("no time")
+	}
+	if e.Details["requested"] != 26.4 || e.Details["temp"] != float64(26) {
+		t.Errorf("details %v, want requested 26.4 and temp 26", e.Details)
+	}
+
+	EventTopic = ""
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":24.4}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-events:
+		t.Errorf("published %s without EventTopic", msg.Payload())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
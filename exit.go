@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// Exit codes of the process, so that a supervisor can tell a requested stop from a failure
+const (
+	// ExitOK is returned when stopped by SIGINT/SIGTERM or a one-shot mode finished
+	ExitOK = 0
+	// ExitFailure is returned for failures without a more specific class
+	ExitFailure = 1
+	// ExitConfig is returned when the configuration is invalid
+	ExitConfig = 2
+	// ExitMQTT is returned when the broker is unreachable or lost for longer than MQTT_WATCHDOG_TIMEOUT
+	ExitMQTT = 3
+	// ExitHardware is returned when LIRC or GPIO is missing or fails to send
+	ExitHardware = 4
+	// ExitInit is returned when gopi cannot create the app, because of invalid flags or a module failing to open
+	ExitInit = 5
+)
+
+// exitError carries the exit code for an error returned from the main task
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	return ExitFailure
+}
+
+// exit logs the final line with the reason, closes LOG_FILE and exits with code
+func exit(code int, reason string) {
+	log.Printf("shutdown: %s (exit code %d)", reason, code)
+	if logOutput != nil {
+		logOutput.Close()
+	}
+	os.Exit(code)
+}
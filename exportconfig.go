@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/djthorpe/gopi"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportConfig returns the effective configuration as the environment variables read by
+// loadConfig, so that it can be fed back to clone a device. Secrets are left out, and
+// so are the credentials and the query of the URLs as done by logConfig.
+func exportConfig() map[string]string {
+	presets := ""
+	if len(Presets) > 0 {
+		b, _ := json.Marshal(Presets)
+		presets = string(b)
+	}
+	pin := func(set bool, v uint8) string {
+		if !set {
+			return ""
+		}
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	duration := func(d time.Duration) string {
+		return d.String()
+	}
+
+	env := map[string]string{
+		"MQTT_HOST":                    redactURL(MQTTHost),
+		"MQTT_USERNAME":                MQTTUserName,
+		"MQTT_VERSION":                 MQTTVersion,
+		"MQTT_SHARED_GROUP":            MQTTSharedGroup,
+		"MQTT_CLIENT_ID_RANDOM_SUFFIX": strconv.FormatBool(MQTTClientIDRandomSuffix),
+		"EVENT_TOPIC":                  EventTopic,
+		"MQTT_WATCHDOG_TIMEOUT":        duration(WatchdogTimeout),
+		"MQTT_TIMEOUT":                 duration(MQTTTimeout),
+		"LOG_LEVEL":                    LogLevel,
+		"LOG_FILE":                     LogFile,
+		"LOG_FILE_MAX_SIZE":            strconv.FormatInt(LogFileMaxSize, 10),
+		"LOG_FILE_MAX_AGE":             duration(LogFileMaxAge),
+		"HTTP_ADDR":                    HTTPAddr,
+		"REPUBLISH_TOPIC":              RepublishTopic,
+		"TIMEZONE":                     TimeZone,
+		"STATE_FILE":                   StateFile,
+		"SCHEDULE_FILE":                ScheduleFile,
+		"CAPABILITIES_FILE":            CapabilitiesFile,
+		"JOURNAL_FILE":                 JournalFile,
+		"JOURNAL_MAX_SIZE":             strconv.FormatInt(JournalMaxSize, 10),
+		"STARTUP_JITTER_MAX":           duration(StartupJitterMax),
+		"MIN_SEND_GAP":                 duration(MinSendGap),
+		"COALESCE":                     strconv.FormatBool(Coalesce),
+		"DEBOUNCE_BYPASS":              strings.Join(DebounceBypass, ","),
+		"DEDUP_WINDOW":                 duration(DedupWindow),
+		"TEMP_STEP":                    strconv.FormatUint(uint64(TempStep), 10),
+		"TEMP_MIN":                     strconv.FormatUint(uint64(TempMin), 10),
+		"TEMP_MAX":                     strconv.FormatUint(uint64(TempMax), 10),
+		"MIN_TEMP_CHANGE":              strconv.Itoa(MinTempChange),
+		"DOUBLE_SEND":                  strconv.FormatBool(DoubleSend),
+		"DOUBLE_SEND_GAP":              duration(DoubleSendGap),
+		"REPEAT_POWER":                 strconv.Itoa(RepeatPower),
+		"REPEAT_ADJUST":                strconv.Itoa(RepeatAdjust),
+		"REASSERT_INTERVAL":            duration(ReassertInterval),
+		"POWERFUL_REVERT":              duration(PowerfulRevert),
+		"LIRC_DEVICE":                  LIRCDevice,
+		"LIRC_DEVICES":                 strings.Join(LIRCDevices, ","),
+		"LIRC_WAIT_ATTEMPTS":           strconv.Itoa(LIRCWaitAttempts),
+		"LIRC_WAIT_INTERVAL":           duration(LIRCWaitInterval),
+		"EMITTERS":                     strings.Join(Emitters, ","),
+		"EMITTER_MQTT_TOPIC":           EmitterMQTTTopic,
+		"EMITTER_FILE":                 EmitterFilePath,
+		"EMITTER_SERIAL_DEVICE":        EmitterSerialDevice,
+		"EMITTER_SERIAL_BAUD":          strconv.Itoa(EmitterSerialBaud),
+		"EMITTER_SERIAL_TIMEOUT":       duration(EmitterSerialTimeout),
+		"EMITTER_FILE_APPEND":          strconv.FormatBool(EmitterFileAppend),
+		"PUBLISH_SPLIT_STATE":          strconv.FormatBool(PublishSplitState),
+		"STATE_CONVENTION":             StateConvention,
+		"STATE_INCLUDE_SIGNAL_HASH":    strconv.FormatBool(StateIncludeSignalHash),
+		"STATE_INCLUDE_TIMESTAMPS":     strconv.FormatBool(StateIncludeTimestamps),
+		"REACTION_DELAY":               os.Getenv("REACTION_DELAY"),
+		"ENERGY_WATTS":                 os.Getenv("ENERGY_WATTS"),
+		"FAN_RAMP":                     duration(FanRamp),
+		"SANITY_RULES":                 os.Getenv("SANITY_RULES"),
+		"INFLUX_URL":                   redactURL(InfluxURL),
+		"INFLUX_BUCKET":                InfluxBucket,
+		"INFLUX_ORG":                   InfluxOrg,
+		"WEATHER_API_URL":              redactURL(WeatherURL),
+		"WEATHER_TEMP_FIELD":           WeatherTempField,
+		"WEATHER_INTERVAL":             duration(WeatherInterval),
+		"WEATHER_NO_HEAT_ABOVE":        strconv.FormatFloat(OutsideNoHeatAbove, 'f', -1, 64),
+		"WEATHER_NO_COOL_BELOW":        strconv.FormatFloat(OutsideNoCoolBelow, 'f', -1, 64),
+		"INFLUX_INTERVAL":              duration(InfluxInterval),
+		"LOG_PRONTO":                   strconv.FormatBool(LogPronto),
+		"RESTORE_FROM_STATE_TOPIC":     strconv.FormatBool(RestoreFromStateTopic),
+		"PASSIVE_SYNC":                 strconv.FormatBool(PassiveSync),
+		"PRESETS":                      presets,
+		"ALLOW_HOURS":                  os.Getenv("ALLOW_HOURS"),
+		"FORBID_HOURS":                 os.Getenv("FORBID_HOURS"),
+		"PREPROCESS_URL":               redactURL(PreprocessURL),
+		"PREPROCESS_TIMEOUT":           duration(PreprocessTimeout),
+		"PREPROCESS_FAIL_OPEN":         strconv.FormatBool(PreprocessFailOpen),
+		"NOTIFY_UNCHANGED":             NotifyUnchanged,
+		"NOTIFY_ROUTES":                os.Getenv("NOTIFY_ROUTES"),
+		"UNIT_NAME":                    UnitName,
+		"UNIT_ICON":                    UnitIcon,
+		"BUTTON_PIN":                   pin(ButtonPin != gopi.GPIO_PIN_NONE, uint8(ButtonPin)),
+		"BUTTON_COMMAND":               ButtonCommand,
+		"BUTTON_DEBOUNCE":              duration(ButtonDebounce),
+		"IDENTIFY_PIN":                 pin(IdentifyPin != gopi.GPIO_PIN_NONE, uint8(IdentifyPin)),
+		"FEEDBACK_TOPIC":               FeedbackTopic,
+		"FEEDBACK_THRESHOLD":           strconv.FormatFloat(FeedbackThreshold, 'f', -1, 64),
+		"FEEDBACK_TIMEOUT":             duration(FeedbackTimeout),
+		"FEEDBACK_RESEND":              strconv.FormatBool(FeedbackResend),
+		"AMBIENT_TOPIC":                AmbientTopic,
+		"SETPOINT_THRESHOLD":           strconv.FormatFloat(SetpointThreshold, 'f', -1, 64),
+		"SETPOINT_HYSTERESIS":          strconv.FormatFloat(SetpointHysteresis, 'f', -1, 64),
+		"SHUTDOWN_SAFE_STATE":          ShutdownSafeState,
+		"SHUTDOWN_TIMEOUT":             duration(ShutdownTimeout),
+		"PRESENCE_TOPIC":               PresenceTopic,
+		"PRESENCE_MODE":                PresenceMode,
+		"PRESENCE_DEFER_MAX":           duration(PresenceDeferMax),
+		"VACATION_CYCLE_STATE":         VacationCycle,
+		"VACATION_CYCLE_INTERVAL":      duration(VacationCycleInterval),
+		"VACATION_CYCLE_DURATION":      duration(VacationCycleDuration),
+		"VACATION_FREEZE_TEMP":         strconv.FormatFloat(VacationFreezeTemp, 'f', -1, 64),
+	}
+	for k, v := range env {
+		if len(v) == 0 {
+			delete(env, k)
+		}
+	}
+	return env
+}
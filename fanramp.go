@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"strconv"
+	"time"
+)
+
+// TagFanRamp marks the steps of a fan ramp after a power-on
+const TagFanRamp = "fan_ramp"
+
+// longest FanRamp accepted
+const maxFanRamp = 10 * time.Minute
+
+// fanRampSteps returns the fan speeds c starts with before its own when it powers the
+// unit on with FanRamp, none otherwise or when its fan is already the lowest. At most
+// 4 speeds are gone through.
+func fanRampSteps(last, c *A75C4269.Controller) []byte {
+	if FanRamp <= 0 || powerName(c.Power) != "on" || (last != nil && powerName(last.Power) == "on") {
+		return nil
+	}
+	switch c.AirVolume {
+	case A75C4269.AirVolume2:
+		return []byte{A75C4269.AirVolume1}
+	case A75C4269.AirVolume3, A75C4269.AirVolumeAuto:
+		return []byte{A75C4269.AirVolume1, A75C4269.AirVolume2}
+	case A75C4269.AirVolume4:
+		return []byte{A75C4269.AirVolume1, A75C4269.AirVolume2, A75C4269.AirVolume3}
+	case A75C4269.AirVolumePowerful:
+		return []byte{A75C4269.AirVolume1, A75C4269.AirVolume2, A75C4269.AirVolume3, A75C4269.AirVolume4}
+	}
+	return nil
+}
+
+// startFanRamp emits c at the first of steps and schedules the others, then c itself,
+// evenly over FanRamp. A new command cancels the steps left like the other internal
+// steps.
+func (s *service) startFanRamp(c *A75C4269.Controller, steps []byte) error {
+	target := c.AirVolume
+	s.fanTarget = &target
+	first := *c
+	first.AirVolume = steps[0]
+	s.app.Logger.Info("fan ramp started: %s to %s over %v", airVolumeName(steps[0]), airVolumeName(target), FanRamp)
+	if err := s.submit(&first); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixNano(), 36)
+	interval := FanRamp / time.Duration(len(steps))
+	for i := 1; i <= len(steps); i++ {
+		step := *c
+		if i < len(steps) {
+			step.AirVolume = steps[i]
+		}
+		at := now.Add(time.Duration(i) * interval)
+		sc := &ScheduledCommand{ID: fmt.Sprintf("%s-%d/%d", id, i, len(steps)), At: at, Controller: step, Tag: TagFanRamp}
+		if err := s.schedule.add(sc); err != nil {
+			// the steps already added would stop the ramp halfway, the unit stays at the
+			// first speed instead. Other commands cancelled the steps of earlier ramps.
+			if _, err := s.schedule.cancelMatching(&CancelRequest{Tag: TagFanRamp}); err != nil {
+				s.app.Logger.Error("fan ramp: %v", err)
+			}
+			s.fanTarget = nil
+			s.fail(fmt.Errorf("fan ramp aborted at %s: %v", airVolumeName(steps[0]), err))
+			break
+		}
+	}
+	s.publishSchedule()
+	return nil
+}
+
+// fanRampStep is called before a step of the fan ramp is emitted, the ramp is over
+// with the step at the target speed
+func (s *service) fanRampStep(c *ScheduledCommand) {
+	s.app.Logger.Info("fan ramp step %s: %s", c.ID, airVolumeName(c.Controller.AirVolume))
+	if s.fanTarget != nil && *s.fanTarget == c.Controller.AirVolume {
+		s.fanTarget = nil
+	}
+}
+
+// rampedState returns the last state, at the target fan speed during a fan ramp so
+// that a command which does not set the fan does not stop the ramp halfway
+func (s *service) rampedState() *A75C4269.Controller {
+	if s.fanTarget == nil || s.last == nil {
+		return s.last
+	}
+	c := *s.last
+	c.AirVolume = *s.fanTarget
+	return &c
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestFanRampSteps(t *testing.T) {
+	on := func(fan byte) *A75C4269.Controller {
+		return &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: fan}
+	}
+	off := &A75C4269.Controller{Power: A75C4269.PowerOff}
+	tests := []struct {
+		name    string
+		fanRamp time.Duration
+		last, c *A75C4269.Controller
+		want    []byte
+	}{
+		{"powerful", time.Minute, off, on(A75C4269.AirVolumePowerful), []byte{A75C4269.AirVolume1, A75C4269.AirVolume2, A75C4269.AirVolume3, A75C4269.AirVolume4}},
+		{"4", time.Minute, off, on(A75C4269.AirVolume4), []byte{A75C4269.AirVolume1, A75C4269.AirVolume2, A75C4269.AirVolume3}},
+		{"auto", time.Minute, nil, on(A75C4269.AirVolumeAuto), []byte{A75C4269.AirVolume1, A75C4269.AirVolume2}},
+		{"2", time.Minute, off, on(A75C4269.AirVolume2), []byte{A75C4269.AirVolume1}},
+		{"lowest", time.Minute, off, on(A75C4269.AirVolume1), nil},
+		{"still", time.Minute, off, on(A75C4269.AirVolumeStill), nil},
+		{"already on", time.Minute, on(A75C4269.AirVolume1), on(A75C4269.AirVolumePowerful), nil},
+		{"power off", time.Minute, off, &A75C4269.Controller{Power: A75C4269.PowerOff, AirVolume: A75C4269.AirVolumePowerful}, nil},
+		{"no FAN_RAMP", 0, off, on(A75C4269.AirVolumePowerful), nil},
+	}
+	fanRamp := FanRamp
+	defer func() { FanRamp = fanRamp }()
+	for _, tt := range tests {
+		FanRamp = tt.fanRamp
+		if got := fanRampSteps(tt.last, tt.c); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestFanRampAborted checks a ramp whose steps cannot be scheduled leaves no step
+// behind and stops overriding the fan of the next commands
+func TestFanRampAborted(t *testing.T) {
+	dir, restore := testConfig(t)
+	defer restore()
+	fanRamp := FanRamp
+	FanRamp = time.Minute
+	defer func() { FanRamp = fanRamp }()
+
+	s := newService(testApp(t), nil)
+	// the schedule cannot be saved into a directory
+	s.schedule.path = dir
+	c := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume4}
+	if err := s.startFanRamp(c, fanRampSteps(nil, c)); err != nil {
+		t.Fatal(err)
+	}
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want the first step", n)
+	}
+	if s.last == nil || s.last.AirVolume != A75C4269.AirVolume1 {
+		t.Errorf("state %+v, want the first speed", s.last)
+	}
+	for _, sc := range s.schedule.list() {
+		if sc.Tag == TagFanRamp {
+			t.Errorf("step %s left scheduled", sc.ID)
+		}
+	}
+	if s.fanTarget != nil {
+		t.Errorf("fan target %d left", *s.fanTarget)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedback waits for a power sensor on FeedbackTopic to confirm that the aircon
+// actually started after a power-on was emitted
+type feedback struct {
+	state  *A75C4269.Controller
+	resent bool
+	timer  <-chan time.Time
+}
+
+// expectFeedback starts waiting for the sensor to confirm that c was applied
+func (s *service) expectFeedback(c *A75C4269.Controller, resent bool) {
+	s.feedback = feedback{
+		state:  c,
+		resent: resent,
+		timer:  time.After(FeedbackTimeout),
+	}
+}
+
+// handleFeedback handles a sensor reading on FeedbackTopic, a reading at or above the
+// positive FeedbackThreshold confirms the power-on awaited
+func (s *service) handleFeedback(payload []byte) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		s.app.Logger.Warn("invalid feedback value: %v", err)
+		return
+	}
+	if s.feedback.state == nil || value < FeedbackThreshold {
+		return
+	}
+	s.app.Logger.Debug("power-on confirmed by feedback: %v", value)
+	s.feedback = feedback{}
+	s.setConfirmed(true)
+}
+
+// Warning is published on WarningTopic when the aircon likely ignored a state
+type Warning struct {
+	Warning string        `json:"warning"`
+	State   *DecodedState `json:"state"`
+}
+
+// setConfirmed publishes whether the feedback confirmed the last power-on
+func (s *service) setConfirmed(confirmed bool) {
+	s.view.setConfirmed(confirmed)
+	if err := s.publish(PubTopic+"/confirmed", 1, true, strconv.FormatBool(confirmed)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
+
+// feedbackTimeout is called when the sensor did not confirm the state in time
+func (s *service) feedbackTimeout() error {
+	f := s.feedback
+	s.feedback = feedback{}
+
+	s.app.Logger.Warn("no feedback within %v after power-on", FeedbackTimeout)
+	if !FeedbackResend || f.resent {
+		// the IR was likely not received at all
+		s.setConfirmed(false)
+		payload, _ := json.Marshal(&Warning{
+			Warning: fmt.Sprintf("power-on not confirmed by feedback within %v", FeedbackTimeout),
+			State:   decodeState(f.state),
+		})
+		if err := s.publish(WarningTopic, 1, false, string(payload)); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	s.app.Logger.Info("resending power-on")
+	if err := s.submit(f.state); err != nil {
+		return err
+	}
+	s.expectFeedback(f.state, true)
+	return nil
+}
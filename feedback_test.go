@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestHandleFeedback(t *testing.T) {
+	threshold := FeedbackThreshold
+	FeedbackThreshold = 50
+	defer func() { FeedbackThreshold = threshold }()
+
+	tests := []struct {
+		name      string
+		awaiting  bool
+		readings  []string
+		confirmed bool
+	}{
+		{"idle sensor", true, []string{"0", "4.5"}, false},
+		{"running", true, []string{"0", "235.4"}, true},
+		{"at the threshold", true, []string{"50"}, true},
+		{"invalid reading", true, []string{"on"}, false},
+		{"not awaiting", false, []string{"235.4"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, restore := testConfig(t)
+			defer restore()
+			s := newService(testApp(t), nil)
+			if tt.awaiting {
+				s.expectFeedback(&A75C4269.Controller{Power: A75C4269.PowerOn}, false)
+			}
+			for _, r := range tt.readings {
+				s.handleFeedback([]byte(r))
+			}
+			confirmed := s.view.snapshot().Confirmed
+			if got := confirmed != nil && *confirmed; got != tt.confirmed {
+				t.Errorf("confirmed %v, want %v", got, tt.confirmed)
+			}
+			if waiting := s.feedback.state != nil; waiting == tt.confirmed && tt.awaiting {
+				t.Errorf("still waiting %v after confirmed %v", waiting, tt.confirmed)
+			}
+		})
+	}
+}
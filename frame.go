@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/wtks/A75C4269"
+)
+
+const (
+	// index of the checksum byte in the data frame
+	checksumIndex = 18
+)
+
+// decodeFrames parses AEHA format timings back into the bytes of each frame
+func decodeFrames(raw []uint32) ([][]byte, error) {
+	var frames [][]byte
+	for i := 0; i < len(raw); {
+		// leader
+		if i+1 >= len(raw) || raw[i] < A75C4269.T8/2 || raw[i+1] < A75C4269.T4/2 {
+			return nil, fmt.Errorf("missing leader at %d", i)
+		}
+		i += 2
+
+		// data bits until the tracer, spaces longer than T*5 end the frame
+		var bits []byte
+		for ; i+1 < len(raw) && raw[i+1] < A75C4269.T*5; i += 2 {
+			if raw[i+1] < A75C4269.T*2 {
+				bits = append(bits, 0)
+			} else {
+				bits = append(bits, 1)
+			}
+		}
+		if i >= len(raw) {
+			return nil, fmt.Errorf("missing tracer of frame %d", len(frames))
+		}
+		i += 2
+
+		if len(bits)%8 != 0 {
+			return nil, fmt.Errorf("frame %d has %d bits, not a multiple of 8", len(frames), len(bits))
+		}
+		frame := make([]byte, len(bits)/8)
+		for j, b := range bits {
+			frame[j/8] |= b << uint(j%8)
+		}
+		// the encoder sends the upper nibble of the third byte first
+		if len(frame) > 2 {
+			frame[2] = frame[2]<<4 | frame[2]>>4
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// checksum computes the checksum of a data frame as specified in spec.md of A75C4269
+func checksum(frame []byte) byte {
+	sum := 0x06
+	for i := 5; i < checksumIndex; i++ {
+		sum += int(frame[i])
+	}
+	return byte(0xFF & sum)
+}
+
+// verifySignal checks that raw consists of the call sign and a data frame with a valid checksum
+func verifySignal(raw []uint32) error {
+	frames, err := decodeFrames(raw)
+	if err != nil {
+		return err
+	}
+	if len(frames) != 2 {
+		return fmt.Errorf("expected 2 frames, got %d", len(frames))
+	}
+	if len(frames[1]) != checksumIndex+1 {
+		return fmt.Errorf("expected %d bytes in data frame, got %d", checksumIndex+1, len(frames[1]))
+	}
+	if sum := checksum(frames[1]); sum != frames[1][checksumIndex] {
+		return fmt.Errorf("checksum mismatch: computed 0x%02X, expected 0x%02X", sum, frames[1][checksumIndex])
+	}
+	return nil
+}
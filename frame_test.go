@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestDecodeFrames(t *testing.T) {
+	tests := []A75C4269.Controller{
+		{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26},
+		{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 20, AirVolume: A75C4269.AirVolume3, WindDirection: A75C4269.WindDirection2},
+		{Power: A75C4269.PowerOff, Mode: A75C4269.ModeDehumidifier, PresetTemp: 24, AirVolume: A75C4269.AirVolumePowerful},
+	}
+	for _, c := range tests {
+		frames, err := decodeFrames(c.GetRawSignal())
+		if err != nil {
+			t.Errorf("%+v: %v", c, err)
+			continue
+		}
+		if len(frames) != 2 {
+			t.Errorf("%+v: got %d frames, want 2", c, len(frames))
+			continue
+		}
+		if want := []byte{0x02, 0x20, 0x0E, 0x04, 0x00, 0x00, 0x00, 0x06}; !bytes.Equal(frames[0], want) {
+			t.Errorf("%+v: call sign % X, want % X", c, frames[0], want)
+		}
+		if want := c.GetSignalBytes(); !bytes.Equal(frames[1], want) {
+			t.Errorf("%+v: data frame % X, want % X", c, frames[1], want)
+		}
+	}
+}
+
+func TestVerifySignal(t *testing.T) {
+	c := A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	valid := c.GetRawSignal()
+
+	// the call sign takes a leader, 64 bits, the tracer and its space, then the data
+	// frame has a leader before its bits
+	bitSpace := func(byteIndex, bit int) int {
+		return 2 + 64*2 + 2 + 2 + (byteIndex*8+bit)*2 + 1
+	}
+	flip := func(i int) []uint32 {
+		raw := append([]uint32(nil), valid...)
+		if raw[i] < A75C4269.T*2 {
+			raw[i] = A75C4269.T3
+		} else {
+			raw[i] = A75C4269.T
+		}
+		return raw
+	}
+
+	tests := []struct {
+		name string
+		raw  []uint32
+		err  string
+	}{
+		{"valid", valid, ""},
+		{"flipped mode bit", flip(bitSpace(5, 0)), "checksum mismatch"},
+		{"flipped checksum bit", flip(bitSpace(checksumIndex, 7)), "checksum mismatch"},
+		{"call sign only", valid[:2+64*2+2], "expected 2 frames"},
+		{"no leader", valid[2:], "missing leader"},
+		{"no tracer", valid[:len(valid)-1], "missing tracer"},
+		{"short data frame", append(append([]uint32(nil), valid[:bitSpace(checksumIndex, 0)-1]...), A75C4269.T), "expected 19 bytes"},
+	}
+	for _, tt := range tests {
+		err := verifySignal(tt.raw)
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// TestRawNotVerified checks a raw frame of another protocol is sent as is, only the
+// frames of the encoder are verified
+func TestRawNotVerified(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+
+	s := newService(testApp(t), nil)
+	// a NEC frame: leader, 32 bits and the stop bit
+	raw := []uint32{9000, 4500}
+	for i := 0; i < 32; i++ {
+		raw = append(raw, 560, 560)
+	}
+	raw = append(raw, 560)
+	payload, _ := json.Marshal(&Command{Raw: raw})
+	if err := s.handleAction(payload, sourceHTTP); err != nil {
+		t.Fatal(err)
+	}
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want the raw frame", n)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djthorpe/gopi"
+	"github.com/djthorpe/gopi/sys/logger"
+)
+
+// testApp returns an app with a silent logger and no hardware
+func testApp(t *testing.T) *gopi.AppInstance {
+	l, err := gopi.Open(logger.Config{Level: logger.LOG_NONE}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gopi.AppInstance{Logger: l.(gopi.Logger)}
+}
+
+// testConfig points StateFile, ScheduleFile and the file emitter into a new temporary
+// directory, restore puts the configuration back and removes the directory
+func testConfig(t *testing.T) (dir string, restore func()) {
+	dir, err := ioutil.TempDir("", "aircon_ir_emitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile, scheduleFile, emitters, emitterFile, convention := StateFile, ScheduleFile, Emitters, EmitterFilePath, StateConvention
+	StateFile = filepath.Join(dir, "state")
+	ScheduleFile = filepath.Join(dir, "schedule")
+	Emitters = []string{EmitterFile}
+	EmitterFilePath = filepath.Join(dir, "emitted")
+	StateConvention = StateConventionNative
+	return dir, func() {
+		StateFile, ScheduleFile, Emitters, EmitterFilePath, StateConvention = stateFile, scheduleFile, emitters, emitterFile, convention
+		os.RemoveAll(dir)
+	}
+}
+
+// emittedFrames returns the number of frames written by the file emitter
+func emittedFrames(t *testing.T) int {
+	b, err := ioutil.ReadFile(EmitterFilePath)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "# ") {
+			n++
+		}
+	}
+	return n
+}
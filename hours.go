@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hourWindow is a daily window of time in Location, from inclusive and to exclusive.
+// It wraps past midnight when to is before from.
+type hourWindow struct {
+	from, to time.Duration
+}
+
+func (w hourWindow) contains(t time.Time) bool {
+	t = t.In(Location)
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.from <= w.to {
+		return d >= w.from && d < w.to
+	}
+	return d >= w.from || d < w.to
+}
+
+// parseHourWindows parses comma separated windows like "22-6" or "22:30-06:00"
+func parseHourWindows(v string) ([]hourWindow, error) {
+	var windows []hourWindow
+	for _, s := range strings.Split(v, ",") {
+		bounds := strings.Split(strings.TrimSpace(s), "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid window: %s", s)
+		}
+		var w hourWindow
+		for i, dst := range []*time.Duration{&w.from, &w.to} {
+			d, err := parseTimeOfDay(bounds[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid window: %s", s)
+			}
+			*dst = d
+		}
+		if w.from == w.to {
+			return nil, fmt.Errorf("empty window: %s", s)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses "6" or "06:30" as the duration since midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var h, m int
+	if strings.Contains(s, ":") {
+		if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil {
+			return 0, err
+		}
+	} else if _, err := fmt.Sscanf(s, "%d", &h); err != nil {
+		return 0, err
+	}
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// checkPowerOnHours returns an error when powering on at t is outside AllowHours or inside ForbidHours
+func checkPowerOnHours(t time.Time) error {
+	for _, w := range ForbidHours {
+		if w.contains(t) {
+			return fmt.Errorf("power on is forbidden at %s (FORBID_HOURS)", t.In(Location).Format("15:04"))
+		}
+	}
+	if len(AllowHours) == 0 {
+		return nil
+	}
+	for _, w := range AllowHours {
+		if w.contains(t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("power on is not allowed at %s (ALLOW_HOURS)", t.In(Location).Format("15:04"))
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ActionPath emits a command like SubTopic
+	ActionPath = "/aircon/action"
+	// RawPath encodes a command into raw timings without sending it
+	RawPath = "/aircon/raw"
+	// PresetPath is followed by the name of the preset to emit
+	PresetPath = "/aircon/preset/"
+	// PresetsPath lists the presets
+	PresetsPath = "/aircon/presets"
+	// VersionPath returns the VersionInfo
+	VersionPath = "/version"
+	// SetpointPath emits a Setpoint
+	SetpointPath = "/aircon/setpoint"
+	// CapabilitiesPath returns the Capabilities of the remote
+	CapabilitiesPath = "/aircon/capabilities"
+	// ConfigPath returns the effective configuration as environment variables
+	ConfigPath = "/aircon/config"
+	// UnitsPath returns the UnitState of every unit by name
+	UnitsPath = "/aircon/units"
+	// StatsPath returns the transmission Stats
+	StatsPath = "/aircon/stats"
+	// EnergyPath returns the EnergyEstimate
+	EnergyPath = "/aircon/energy"
+	// DescribePath returns the Description of a command without sending it
+	DescribePath = "/aircon/describe"
+	// RecurringPath lists and adds the RecurringRule, followed by an id it removes one
+	RecurringPath = "/aircon/recurring"
+	// SchedulePath lists the scheduled commands, DELETE cancels them like CancelTopic
+	SchedulePath = "/aircon/schedule"
+)
+
+// codes of APIError
+const (
+	// APIErrorInvalidRequest is a body which cannot be decoded or is rejected as a whole
+	APIErrorInvalidRequest = "invalid_request"
+	// APIErrorInvalidValue is an invalid value of the field of APIError
+	APIErrorInvalidValue = "invalid_value"
+	// APIErrorNotFound is an unknown preset, scheduled command or recurring rule
+	APIErrorNotFound = "not_found"
+	// APIErrorMethodNotAllowed is a method the path does not handle
+	APIErrorMethodNotAllowed = "method_not_allowed"
+	// APIErrorBusy is a command not taken by the loop in time
+	APIErrorBusy = "busy"
+	// APIErrorRejected is a command refused by the loop, with wait=true
+	APIErrorRejected = "rejected"
+	// APIErrorEmitFailed is a command whose frame no emitter could send, with wait=true
+	APIErrorEmitFailed = "emit_failed"
+	// APIErrorTimeout is a command not handled within the timeout, with wait=true
+	APIErrorTimeout = "timeout"
+)
+
+// how long a request waits for the loop to take its command
+const httpPassTimeout = 5 * time.Second
+
+// APIError is the body of an error reply, under "error"
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// output formats of RawPath
+const (
+	// RawFormatJSON is a JSON array of the timings
+	RawFormatJSON = "json"
+	// RawFormatCSV is the timings separated by commas
+	RawFormatCSV = "csv"
+	// RawFormatBase64 is the timings packed as little endian uint32 and encoded in base64
+	RawFormatBase64 = "base64"
+	// RawFormatPronto is the timings in Pronto HEX at CarrierFrequency
+	RawFormatPronto = "pronto"
+)
+
+// listenHTTP starts the HTTP API on HTTPAddr, commands are passed to the loop of s.
+// The listener is to be closed by the caller.
+func listenHTTP(s *service) (net.Listener, error) {
+	ln, err := net.Listen("tcp", HTTPAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ActionPath, func(w http.ResponseWriter, r *http.Request) {
+		handleActionRequest(w, r, s.requests, s.confirmations)
+	})
+	mux.HandleFunc(RawPath, handleRaw)
+	mux.HandleFunc(DescribePath, handleDescribe)
+	mux.HandleFunc(PresetsPath, handlePresets)
+	mux.HandleFunc(VersionPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versionInfo())
+	})
+	mux.HandleFunc(PresetPath, func(w http.ResponseWriter, r *http.Request) {
+		handlePreset(w, r, s.requests)
+	})
+	mux.HandleFunc(SetpointPath, func(w http.ResponseWriter, r *http.Request) {
+		handleSetpoint(w, r, s.requests)
+	})
+	mux.HandleFunc(CapabilitiesPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Spec.Capabilities())
+	})
+	mux.HandleFunc(ConfigPath, handleConfig)
+	mux.HandleFunc(UnitsPath, func(w http.ResponseWriter, r *http.Request) {
+		// a single unit per process, named by its client id
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]*UnitState{ClientID: s.view.snapshot()})
+	})
+	mux.HandleFunc(StatsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.stats.snapshot())
+	})
+	mux.HandleFunc(EnergyPath, func(w http.ResponseWriter, r *http.Request) {
+		if s.energy == nil {
+			httpError(w, http.StatusNotFound, errors.New("energy estimate is disabled: set ENERGY_WATTS"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.energy.snapshot(time.Now()))
+	})
+	mux.HandleFunc(SchedulePath, func(w http.ResponseWriter, r *http.Request) {
+		handleScheduleRequest(w, r, &s.scheduled, s.cancels)
+	})
+	mux.HandleFunc(RecurringPath, func(w http.ResponseWriter, r *http.Request) {
+		handleRecurring(w, r, s.recurring, s.schedules)
+	})
+	mux.HandleFunc(RecurringPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		handleRecurringRule(w, r, s.recurring, s.schedules)
+	})
+	go http.Serve(ln, mux)
+	return ln, nil
+}
+
+// handleRaw returns the raw timings of the command in the request body in the
+// format given by the format query, or text/csv in Accept
+func handleRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = RawFormatJSON
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = RawFormatCSV
+		}
+	}
+
+	cmd := Command{}
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(cmd.Raw) > 0 || cmd.Boost != nil {
+		httpError(w, http.StatusBadRequest, errors.New("only plain states can be encoded"))
+		return
+	}
+	c, err := cmd.Resolve(nil)
+	if err == nil {
+		err = Spec.Check(c)
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	raw := c.GetRawSignal()
+
+	switch format {
+	case RawFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(raw)
+	case RawFormatCSV:
+		s := make([]string, len(raw))
+		for i, t := range raw {
+			s[i] = strconv.FormatUint(uint64(t), 10)
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		fmt.Fprintln(w, strings.Join(s, ","))
+	case RawFormatBase64:
+		b := make([]byte, 4*len(raw))
+		for i, t := range raw {
+			binary.LittleEndian.PutUint32(b[4*i:], t)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, base64.StdEncoding.EncodeToString(b))
+	case RawFormatPronto:
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, prontoHex(raw, CarrierFrequency))
+	default:
+		httpError(w, http.StatusBadRequest, fmt.Errorf("unknown format: %s (valid: %s, %s, %s, %s)", format, RawFormatJSON, RawFormatCSV, RawFormatBase64, RawFormatPronto))
+	}
+}
+
+// handlePresets returns the presets in decoded form by name
+func handlePresets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	presets := make(map[string]*DecodedState, len(Presets))
+	for name, c := range Presets {
+		c := c
+		presets[name] = decodeState(&c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presets)
+}
+
+// handlePreset passes the preset named in the path to requests, it is emitted asynchronously
+func handlePreset(w http.ResponseWriter, r *http.Request, requests chan<- []byte) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, PresetPath)
+	if _, err := lookupPreset(name); err != nil {
+		httpError(w, http.StatusNotFound, err)
+		return
+	}
+	payload, _ := json.Marshal(map[string]string{"preset": name})
+	commandWaiting()
+	if !pass(w, requests, payload) {
+		commandReceived()
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleActionRequest passes the command in the request body to requests, it is emitted
+// asynchronously, or with the wait query to confirmations to reply its outcome
+func handleActionRequest(w http.ResponseWriter, r *http.Request, requests chan<- []byte, confirmations chan<- *confirmation) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !json.Valid(payload) {
+		httpError(w, http.StatusBadRequest, errors.New("invalid JSON"))
+		return
+	}
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		handleConfirmed(w, r, payload, confirmations)
+		return
+	}
+	commandWaiting()
+	if !pass(w, requests, payload) {
+		commandReceived()
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleSetpoint passes the Setpoint in the request body to requests, it is emitted asynchronously
+func handleSetpoint(w http.ResponseWriter, r *http.Request, requests chan<- []byte) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	sp := Setpoint{}
+	if err := json.NewDecoder(r.Body).Decode(&sp); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload, _ := json.Marshal(map[string]*Setpoint{"setpoint": &sp})
+	commandWaiting()
+	if !pass(w, requests, payload) {
+		commandReceived()
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleRecurring returns the recurring rules, or passes the RecurringRule in the
+// request body to schedules, it is added asynchronously
+func handleRecurring(w http.ResponseWriter, r *http.Request, rules *recurring, schedules chan<- []byte) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules.list())
+	case http.MethodPost:
+		rule := RecurringRule{}
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		payload, _ := json.Marshal(&ScheduleRequest{Recurring: &rule})
+		if pass(w, schedules, payload) {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	default:
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+	}
+}
+
+// handleRecurringRule removes the recurring rule named in the path asynchronously
+func handleRecurringRule(w http.ResponseWriter, r *http.Request, rules *recurring, schedules chan<- []byte) {
+	if r.Method != http.MethodDelete {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, RecurringPath+"/")
+	if rules.get(id) == nil {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no recurring rule: %s", id))
+		return
+	}
+	payload, _ := json.Marshal(&ScheduleRequest{Action: ScheduleActionRemoveRecurring, ID: id})
+	if pass(w, schedules, payload) {
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// handleScheduleRequest returns the scheduled commands, or passes the CancelRequest of
+// the id and tag queries to cancels, they are cancelled asynchronously. An id which is
+// not scheduled is not found.
+func handleScheduleRequest(w http.ResponseWriter, r *http.Request, scheduled *scheduleView, cancels chan<- []byte) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduled.snapshot())
+	case http.MethodDelete:
+		req := CancelRequest{ID: r.URL.Query().Get("id"), Tag: r.URL.Query().Get("tag")}
+		if len(req.ID) > 0 {
+			found := false
+			for _, c := range scheduled.snapshot() {
+				found = found || c.ID == req.ID
+			}
+			if !found {
+				httpError(w, http.StatusNotFound, fmt.Errorf("no scheduled command: %s", req.ID))
+				return
+			}
+		}
+		payload, _ := json.Marshal(&req)
+		if pass(w, cancels, payload) {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	default:
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+	}
+}
+
+// handleConfig returns exportConfig as JSON, or as an env file with format=env
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		return
+	}
+	env := exportConfig()
+	if r.URL.Query().Get("format") != "env" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(env)
+		return
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s=%s\n", name, strconv.Quote(env[name]))
+	}
+}
+
+// httpError replies err as an APIError with the code of status, and the field when
+// err is about a single field of the body
+func httpError(w http.ResponseWriter, status int, err error) {
+	e := &APIError{Code: APIErrorInvalidRequest, Message: err.Error()}
+	switch status {
+	case http.StatusNotFound:
+		e.Code = APIErrorNotFound
+	case http.StatusMethodNotAllowed:
+		e.Code = APIErrorMethodNotAllowed
+	case http.StatusServiceUnavailable:
+		e.Code = APIErrorBusy
+	case http.StatusUnprocessableEntity:
+		e.Code = APIErrorRejected
+	case http.StatusBadGateway:
+		e.Code = APIErrorEmitFailed
+	case http.StatusGatewayTimeout:
+		e.Code = APIErrorTimeout
+	}
+	switch err := err.(type) {
+	case *fieldError:
+		e.Code, e.Field = APIErrorInvalidValue, err.field
+	case *json.UnmarshalTypeError:
+		e.Code, e.Field = APIErrorInvalidValue, err.Field
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]*APIError{"error": e})
+}
+
+// pass passes payload to the loop through ch, replying 503 when it is not taken within
+// httpPassTimeout as the loop is stuck sending
+func pass(w http.ResponseWriter, ch chan<- []byte, payload []byte) bool {
+	select {
+	case ch <- payload:
+		return true
+	case <-time.After(httpPassTimeout):
+		httpError(w, http.StatusServiceUnavailable, errors.New("busy, try again later"))
+		return false
+	}
+}
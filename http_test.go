@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestHandleScheduleRequest(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+
+	s := newService(testApp(t), nil)
+	at := time.Now().Add(time.Hour)
+	for _, c := range []*ScheduledCommand{
+		{ID: "evening", At: at, Controller: A75C4269.Controller{Power: A75C4269.PowerOff}},
+		{ID: "curve", At: at.Add(time.Minute), Controller: A75C4269.Controller{Power: A75C4269.PowerOn, PresetTemp: 27}, Tag: TagSleepCurve},
+	} {
+		if err := s.schedule.add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.publishSchedule()
+
+	tests := []struct {
+		name, method, query string
+		status              int
+		// code of the error, or the ids left once the cancel request is handled
+		code string
+		left []string
+	}{
+		{"list", http.MethodGet, "", http.StatusOK, "", []string{"evening", "curve"}},
+		{"unknown id", http.MethodDelete, "?id=none", http.StatusNotFound, APIErrorNotFound, []string{"evening", "curve"}},
+		{"method", http.MethodPost, "", http.StatusMethodNotAllowed, APIErrorMethodNotAllowed, []string{"evening", "curve"}},
+		{"by tag", http.MethodDelete, "?tag=" + TagSleepCurve, http.StatusAccepted, "", []string{"evening"}},
+		{"by id", http.MethodDelete, "?id=evening", http.StatusAccepted, "", nil},
+	}
+	for _, tt := range tests {
+		cancels := make(chan []byte, 1)
+		w := httptest.NewRecorder()
+		handleScheduleRequest(w, httptest.NewRequest(tt.method, SchedulePath+tt.query, nil), &s.scheduled, cancels)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if len(tt.code) > 0 {
+			body := map[string]*APIError{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] == nil || body["error"].Code != tt.code {
+				t.Errorf("%s: body %s, want the code %s", tt.name, w.Body.String(), tt.code)
+			}
+		}
+		select {
+		case payload := <-cancels:
+			s.handleCancel(payload)
+		default:
+		}
+		if got := ids(s.scheduled.snapshot()); len(got) != len(tt.left) {
+			t.Errorf("%s: left %v, want %v", tt.name, got, tt.left)
+		}
+		if tt.method == http.MethodGet {
+			list := []*ScheduledCommand{}
+			if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 2 || list[0].ID != "evening" {
+				t.Errorf("%s: got %s", tt.name, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestHandleRaw(t *testing.T) {
+	want := (&A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}).GetRawSignal()
+	body := `{"power":"on","mode":"cool","temp":26}`
+
+	tests := []struct {
+		name, method, query, accept string
+		status                      int
+		contentType                 string
+		// decode returns the timings of the body
+		decode func(string) ([]uint32, error)
+	}{
+		{"json", http.MethodPost, "", "", http.StatusOK, "application/json", func(b string) ([]uint32, error) {
+			var raw []uint32
+			err := json.Unmarshal([]byte(b), &raw)
+			return raw, err
+		}},
+		{"csv by accept", http.MethodPost, "", "text/csv", http.StatusOK, "text/csv", decodeCSV},
+		{"csv by query", http.MethodPost, "?format=csv", "application/json", http.StatusOK, "text/csv", decodeCSV},
+		{"base64", http.MethodPost, "?format=base64", "", http.StatusOK, "text/plain", func(b string) ([]uint32, error) {
+			p, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b))
+			raw := make([]uint32, len(p)/4)
+			for i := range raw {
+				raw[i] = binary.LittleEndian.Uint32(p[4*i:])
+			}
+			return raw, err
+		}},
+		{"pronto", http.MethodPost, "?format=pronto", "", http.StatusOK, "text/plain", nil},
+		{"unknown format", http.MethodPost, "?format=xml", "", http.StatusBadRequest, "application/json", nil},
+		{"method", http.MethodGet, "", "", http.StatusMethodNotAllowed, "application/json", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, RawPath+tt.query, strings.NewReader(body))
+		if len(tt.accept) > 0 {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		handleRaw(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: content type %s, want %s", tt.name, ct, tt.contentType)
+		}
+		if tt.decode == nil {
+			continue
+		}
+		raw, err := tt.decode(w.Body.String())
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(raw, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, raw, want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, RawPath+"?format=pronto", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleRaw(w, r)
+	if got := strings.TrimSpace(w.Body.String()); got != prontoHex(want, CarrierFrequency) {
+		t.Errorf("pronto: got %s, want %s", got, prontoHex(want, CarrierFrequency))
+	}
+}
+
+// TestHTTPError checks the code and field replied for the errors of a command, and the
+// code following the status otherwise
+func TestHTTPError(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  func(w http.ResponseWriter)
+		status int
+		want   APIError
+	}{
+		{"invalid name", raw(http.MethodPost, `{"fan":"turbo"}`), http.StatusBadRequest,
+			APIError{Code: APIErrorInvalidValue, Field: "fan"}},
+		{"invalid code", raw(http.MethodPost, `{"winddirection":9}`), http.StatusBadRequest,
+			APIError{Code: APIErrorInvalidValue, Message: "invalid winddirection: 9", Field: "winddirection"}},
+		{"negative temp", raw(http.MethodPost, `{"temp":-1}`), http.StatusBadRequest,
+			APIError{Code: APIErrorInvalidValue, Message: "invalid temp: -1", Field: "temp"}},
+		{"wrong type", raw(http.MethodPost, `{"temp":"warm"}`), http.StatusBadRequest,
+			APIError{Code: APIErrorInvalidValue, Field: "temp"}},
+		{"not json", raw(http.MethodPost, `{"power"`), http.StatusBadRequest,
+			APIError{Code: APIErrorInvalidRequest}},
+		{"method", raw(http.MethodGet, ""), http.StatusMethodNotAllowed,
+			APIError{Code: APIErrorMethodNotAllowed, Message: "method not allowed: GET"}},
+		{"not found", func(w http.ResponseWriter) {
+			httpError(w, http.StatusNotFound, errors.New("unknown preset: away"))
+		}, http.StatusNotFound, APIError{Code: APIErrorNotFound, Message: "unknown preset: away"}},
+		{"busy", func(w http.ResponseWriter) {
+			httpError(w, http.StatusServiceUnavailable, errors.New("busy, try again later"))
+		}, http.StatusServiceUnavailable, APIError{Code: APIErrorBusy, Message: "busy, try again later"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.reply(w)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: content type %s", tt.name, ct)
+		}
+		var v struct {
+			Error *APIError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || v.Error == nil {
+			t.Errorf("%s: body %s", tt.name, w.Body)
+			continue
+		}
+		got := *v.Error
+		if len(got.Message) == 0 {
+			t.Errorf("%s: no message", tt.name)
+		}
+		if len(tt.want.Message) == 0 {
+			got.Message = ""
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// raw replies the command body to RawPath with method
+func raw(method, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		handleRaw(w, httptest.NewRequest(method, RawPath, strings.NewReader(body)))
+	}
+}
+
+func decodeCSV(b string) ([]uint32, error) {
+	var raw []uint32
+	for _, f := range strings.Split(strings.TrimSpace(b), ",") {
+		t, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, uint32(t))
+	}
+	return raw, nil
+}
+
+// testServer serves the HTTP API of s on a free local port, close stops listening
+func testServer(t *testing.T, s *service) (url string, close func()) {
+	addr := HTTPAddr
+	HTTPAddr = "127.0.0.1:0"
+	defer func() { HTTPAddr = addr }()
+	ln, err := listenHTTP(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "http://" + ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestUnits(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+
+	s := newService(testApp(t), nil)
+	e := &testEmitter{name: EmitterFile}
+	s.emitters = []emitter{e}
+	url, close := testServer(t, s)
+	defer close()
+
+	get := func() map[string]*UnitState {
+		units := map[string]*UnitState{}
+		if _, err := getJSON(http.DefaultClient, url+UnitsPath, &units); err != nil {
+			t.Fatal(err)
+		}
+		return units
+	}
+	units := get()
+	if u := units[ClientID]; u == nil || u.State != nil || !u.Available {
+		t.Errorf("got %+v before any send, want an unknown state, available", u)
+	}
+
+	if err := s.handleAction([]byte(`{"power":"on","mode":"heat","temp":22,"fan":"2"}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	units = get()
+	want := DecodedState{Power: "on", Mode: "heat", Temp: 22, Fan: "2", Swing: "auto"}
+	if u := units[ClientID]; u == nil || u.State == nil || *u.State != want || !u.Available {
+		t.Errorf("got %+v, want %+v, available", u, want)
+	}
+
+	e.err = errors.New("failed")
+	s.handleAction([]byte(`{"power":"off"}`), sourceMQTT)
+	units = get()
+	if u := units[ClientID]; u == nil || u.Available || u.State == nil || u.State.Power != "on" {
+		t.Errorf("got %+v, want the last sent state, unavailable", u)
+	}
+	if len(units) != 1 {
+		t.Errorf("%d units, want 1", len(units))
+	}
+}
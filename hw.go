@@ -0,0 +1,27 @@
+//go:build linux && !nohw
+// +build linux,!nohw
+
+package main
+
+import (
+	"github.com/djthorpe/gopi"
+	"github.com/djthorpe/gopi-hw/sys/filepoll"
+	_ "github.com/djthorpe/gopi-hw/sys/gpio"
+	"github.com/djthorpe/gopi-hw/sys/lirc"
+)
+
+// hardware tells whether the LIRC and GPIO modules of gopi-hw are built in, which
+// the nohw build tag and other platforms than linux leave out
+const hardware = true
+
+// openLIRC opens device besides the LIRC module of app
+func openLIRC(app *gopi.AppInstance, device string) (gopi.LIRC, error) {
+	l, err := gopi.Open(lirc.LIRC{
+		Device:   device,
+		FilePoll: app.ModuleInstance("hw/filepoll").(filepoll.FilePollInterface),
+	}, app.Logger)
+	if err != nil {
+		return nil, err
+	}
+	return l.(gopi.LIRC), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/djthorpe/gopi"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	identifyBlinks   = 10
+	identifyInterval = 250 * time.Millisecond
+)
+
+// identifier blinks a status LED so that the unit can be found physically
+type identifier struct {
+	log      gopi.Logger
+	gpio     gopi.GPIO
+	pin      gopi.GPIOPin
+	blinking int32
+}
+
+func newIdentifier(log gopi.Logger, gpio gopi.GPIO, pin gopi.GPIOPin) *identifier {
+	gpio.SetPinMode(pin, gopi.GPIO_OUTPUT)
+	gpio.WritePin(pin, gopi.GPIO_LOW)
+	return &identifier{log: log, gpio: gpio, pin: pin}
+}
+
+// blink blinks the LED in the background, it does nothing while already blinking
+func (i *identifier) blink() {
+	if !atomic.CompareAndSwapInt32(&i.blinking, 0, 1) {
+		return
+	}
+	safeGo(i.log, func() {
+		defer atomic.StoreInt32(&i.blinking, 0)
+		for n := 0; n < identifyBlinks; n++ {
+			i.gpio.WritePin(i.pin, gopi.GPIO_HIGH)
+			time.Sleep(identifyInterval)
+			i.gpio.WritePin(i.pin, gopi.GPIO_LOW)
+			time.Sleep(identifyInterval)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/wtks/A75C4269"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// lines waiting to be written, more are dropped while the endpoint is down
+	influxQueueSize = 256
+	// attempts to write a batch before it is dropped, with a doubling delay between
+	influxAttempts   = 3
+	influxRetryDelay = time.Second
+	influxTimeout    = 10 * time.Second
+)
+
+// influxTagEscaper escapes measurement names, tag keys and tag values of line protocol
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxStringEscaper escapes string field values of line protocol
+var influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+// influxUnit is the unit tag of the points, UnitName or else ClientID
+func influxUnit() string {
+	if len(UnitName) > 0 {
+		return UnitName
+	}
+	return ClientID
+}
+
+// influxStateLine is the point of a state, d is nil while the state is unknown
+func influxStateLine(unit string, d *DecodedState, t time.Time) string {
+	if d == nil {
+		return fmt.Sprintf("aircon_state,unit=%s known=false %d", influxTagEscaper.Replace(unit), t.UnixNano())
+	}
+	return fmt.Sprintf("aircon_state,unit=%s,mode=%s known=true,power=%t,temp=%di,fan=\"%s\",swing=\"%s\" %d",
+		influxTagEscaper.Replace(unit), influxTagEscaper.Replace(d.Mode),
+		d.Power == "on", d.Temp, influxStringEscaper.Replace(d.Fan), influxStringEscaper.Replace(d.Swing), t.UnixNano())
+}
+
+// influxSendLine is the point of a transmission through emitter which took took and
+// failed with err if not nil
+func influxSendLine(unit, emitter string, took time.Duration, err error, t time.Time) string {
+	if len(emitter) == 0 {
+		emitter = "none"
+	}
+	return fmt.Sprintf("aircon_send,unit=%s,emitter=%s success=%t,duration_ms=%s %d",
+		influxTagEscaper.Replace(unit), influxTagEscaper.Replace(emitter),
+		err == nil, strconv.FormatFloat(float64(took)/float64(time.Millisecond), 'f', -1, 64), t.UnixNano())
+}
+
+// influxStatsLine is the point of the Stats since startup
+func influxStatsLine(unit string, s Stats, t time.Time) string {
+	return fmt.Sprintf("aircon_stats,unit=%s sends=%di,successes=%di,failures=%di,avg_duration_ms=%s %d",
+		influxTagEscaper.Replace(unit), s.Sends, s.Successes, s.Failures,
+		strconv.FormatFloat(s.AvgDurationMs, 'f', -1, 64), t.UnixNano())
+}
+
+// influx writes points to the write API of InfluxDB from its own goroutine, so that
+// the loop never waits for the endpoint
+type influx struct {
+	log   gopi.Logger
+	url   string
+	token string
+	lines chan string
+}
+
+// newInflux returns the writer to InfluxURL, nil unless it is set
+func newInflux(log gopi.Logger) *influx {
+	if len(InfluxURL) == 0 {
+		return nil
+	}
+	q := url.Values{}
+	q.Set("bucket", InfluxBucket)
+	q.Set("precision", "ns")
+	if len(InfluxOrg) > 0 {
+		q.Set("org", InfluxOrg)
+	}
+	return &influx{
+		log:   log,
+		url:   strings.TrimRight(InfluxURL, "/") + "/api/v2/write?" + q.Encode(),
+		token: InfluxToken,
+		lines: make(chan string, influxQueueSize),
+	}
+}
+
+// add queues line, it is dropped when the queue is full
+func (x *influx) add(line string) {
+	select {
+	case x.lines <- line:
+	default:
+		x.log.Warn("influx: queue full, point dropped")
+	}
+}
+
+// run writes the queued lines in batches, and the lines of periodic every
+// InfluxInterval. It never returns.
+func (x *influx) run(periodic func() []string) {
+	tick := time.NewTicker(InfluxInterval)
+	defer tick.Stop()
+	for {
+		var batch []string
+		select {
+		case line := <-x.lines:
+			batch = append(batch, line)
+		case <-tick.C:
+			batch = append(batch, periodic()...)
+		}
+		// take what else is queued along
+	more:
+		for len(batch) < influxQueueSize {
+			select {
+			case line := <-x.lines:
+				batch = append(batch, line)
+			default:
+				break more
+			}
+		}
+		x.write(batch)
+	}
+}
+
+// write posts batch, retrying influxAttempts times before dropping it
+func (x *influx) write(batch []string) {
+	body := []byte(strings.Join(batch, "\n") + "\n")
+	delay := influxRetryDelay
+	for i := 1; ; i++ {
+		err := x.post(body)
+		if err == nil {
+			return
+		}
+		if i >= influxAttempts {
+			x.log.Error("influx: %d points dropped after %d attempts: %v", len(batch), i, err)
+			return
+		}
+		x.log.Warn("influx: %v, retrying in %v", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (x *influx) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, x.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(x.token) > 0 {
+		req.Header.Set("Authorization", "Token "+x.token)
+	}
+	client := http.Client{Timeout: influxTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("write returned %s", res.Status)
+	}
+	return nil
+}
+
+// influxPeriodic returns the points written every InfluxInterval, read from the copies
+// kept for the readers outside the loop
+func (s *service) influxPeriodic() []string {
+	now := time.Now()
+	return []string{
+		influxStateLine(influxUnit(), s.view.snapshot().State, now),
+		influxStatsLine(influxUnit(), s.stats.snapshot(), now),
+	}
+}
+
+// influxState queues the point of c as it is set
+func (s *service) influxState(c *A75C4269.Controller) {
+	if s.influx == nil {
+		return
+	}
+	var d *DecodedState
+	if c != nil {
+		d = decodeState(c)
+	}
+	s.influx.add(influxStateLine(influxUnit(), d, time.Now()))
+}
+
+// influxSend queues the point of a transmission, the emitter is the one which
+// delivered it
+func (s *service) influxSend(took time.Duration, err error) {
+	if s.influx == nil {
+		return
+	}
+	emitter := ""
+	if err == nil {
+		emitter = s.delivered
+	}
+	s.influx.add(influxSendLine(influxUnit(), emitter, took, err, time.Now()))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxLines(t *testing.T) {
+	at := time.Unix(1700000000, 123)
+	tests := []struct {
+		name, line, want string
+	}{
+		{"state",
+			influxStateLine("living", &DecodedState{Power: "on", Mode: "cool", Temp: 26, Fan: "auto", Swing: "auto"}, at),
+			`aircon_state,unit=living,mode=cool known=true,power=true,temp=26i,fan="auto",swing="auto" 1700000000000000123`},
+		{"state off",
+			influxStateLine("living", &DecodedState{Power: "off", Mode: "heat", Temp: 20, Fan: "2", Swing: "down"}, at),
+			`aircon_state,unit=living,mode=heat known=true,power=false,temp=20i,fan="2",swing="down" 1700000000000000123`},
+		{"unknown state",
+			influxStateLine("living", nil, at),
+			`aircon_state,unit=living known=false 1700000000000000123`},
+		{"escaped tags and strings",
+			influxStateLine(`living room,1=a`, &DecodedState{Power: "on", Mode: "cool", Temp: 26, Fan: `a"b`, Swing: `c\d`}, at),
+			`aircon_state,unit=living\ room\,1\=a,mode=cool known=true,power=true,temp=26i,fan="a\"b",swing="c\\d" 1700000000000000123`},
+		{"send",
+			influxSendLine("living", EmitterLIRC, 12500*time.Microsecond, nil, at),
+			`aircon_send,unit=living,emitter=` + EmitterLIRC + ` success=true,duration_ms=12.5 1700000000000000123`},
+		{"failed send",
+			influxSendLine("living", "", 3*time.Millisecond, errors.New("timeout"), at),
+			`aircon_send,unit=living,emitter=none success=false,duration_ms=3 1700000000000000123`},
+		{"stats",
+			influxStatsLine("living", Stats{Sends: 10, Successes: 9, Failures: 1, AvgDurationMs: 8.25}, at),
+			`aircon_stats,unit=living sends=10i,successes=9i,failures=1i,avg_duration_ms=8.25 1700000000000000123`},
+	}
+	for _, tt := range tests {
+		if tt.line != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, tt.line, tt.want)
+		}
+	}
+}
+
+// TestInfluxWrite checks the request of a batch to the write API
+func TestInfluxWrite(t *testing.T) {
+	type request struct {
+		method, path, bucket, org, precision, auth, contentType, body string
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		q := r.URL.Query()
+		requests <- request{r.Method, r.URL.Path, q.Get("bucket"), q.Get("org"), q.Get("precision"),
+			r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(b)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	u, token, bucket, org := InfluxURL, InfluxToken, InfluxBucket, InfluxOrg
+	InfluxURL, InfluxToken, InfluxBucket, InfluxOrg = server.URL+"/", "secret", "aircon", "home"
+	defer func() { InfluxURL, InfluxToken, InfluxBucket, InfluxOrg = u, token, bucket, org }()
+
+	x := newInflux(testApp(t).Logger)
+	at := time.Unix(1700000000, 0)
+	x.write([]string{influxStateLine("living", nil, at), influxStatsLine("living", Stats{}, at)})
+
+	select {
+	case r := <-requests:
+		want := request{http.MethodPost, "/api/v2/write", "aircon", "home", "ns", "Token secret", "text/plain; charset=utf-8",
+			"aircon_state,unit=living known=false 1700000000000000000\n" +
+				"aircon_stats,unit=living sends=0i,successes=0i,failures=0i,avg_duration_ms=0 1700000000000000000\n"}
+		if r != want {
+			t.Errorf("got %+v, want %+v", r, want)
+		}
+	default:
+		t.Fatal("nothing written")
+	}
+}
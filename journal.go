@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// JournalEntry is a command accepted on SubTopic, written as a line of JOURNAL_FILE
+type JournalEntry struct {
+	Time    time.Time       `json:"time"`
+	Command json.RawMessage `json:"command"`
+}
+
+// journal appends accepted commands to a file, rotating it to path.1 once it exceeds maxSize
+type journal struct {
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+func openJournal(path string, maxSize int64) (*journal, error) {
+	j := &journal{path: path, maxSize: maxSize}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *journal) open() error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.f = f
+	j.size = info.Size()
+	return nil
+}
+
+func (j *journal) rotate() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(j.path, j.path+".1"); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+func (j *journal) write(command []byte) error {
+	b, err := json.Marshal(&JournalEntry{Time: time.Now(), Command: command})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if j.size > 0 && j.size+int64(len(b)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.f.Write(b)
+	j.size += int64(n)
+	return err
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// readJournal reads all entries of a journal file
+func readJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		e := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lircDevice is a LIRC device found under /dev
+type lircDevice struct {
+	path    string
+	send    bool
+	receive bool
+	err     error
+}
+
+func (d *lircDevice) String() string {
+	if d.err != nil {
+		return d.path + " (" + d.err.Error() + ")"
+	}
+	var can []string
+	if d.send {
+		can = append(can, "send")
+	}
+	if d.receive {
+		can = append(can, "receive")
+	}
+	if len(can) == 0 {
+		can = append(can, "no send or receive")
+	}
+	return d.path + " (" + strings.Join(can, ", ") + ")"
+}
+
+// detectLIRC returns the LIRC devices under /dev in the order of their numbers, with
+// what each of them can do
+func detectLIRC() []*lircDevice {
+	paths, _ := filepath.Glob("/dev/lirc[0-9]*")
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) < len(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+	devices := make([]*lircDevice, 0, len(paths))
+	for _, path := range paths {
+		d := &lircDevice{path: path}
+		d.send, d.receive, d.err = lircFeatures(path)
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// chooseLIRC logs the LIRC devices found and, unless LIRC_DEVICE or LIRC_DEVICES pin
+// the device, makes the first one which can send LIRCDevice. It tells whether the
+// device was chosen, LIRCDevice is left to its default otherwise.
+func chooseLIRC() bool {
+	devices := detectLIRC()
+	if len(devices) == 0 {
+		log.Printf("no LIRC device found under /dev")
+	}
+	for _, d := range devices {
+		log.Printf("LIRC device: %v", d)
+	}
+	if len(os.Getenv("LIRC_DEVICE")) > 0 || len(LIRCDevices) > 0 {
+		log.Printf("LIRC device pinned to %s", LIRCDevice)
+		return false
+	}
+	for _, d := range devices {
+		if d.send {
+			LIRCDevice = d.path
+			log.Printf("LIRC device %s chosen as the first which can send", LIRCDevice)
+			return true
+		}
+	}
+	log.Printf("no LIRC device can send, using %s", LIRCDevice)
+	return false
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// _IOR('i', 0x00, __u32) of linux/lirc.h
+	lircGetFeatures = 0x80046900
+	lircCanSendMask = 0x0000003F
+	lircCanRecMask  = lircCanSendMask << 16
+)
+
+// lircFeatures tells whether the LIRC device at path can send and receive
+func lircFeatures(path string) (send, receive bool, err error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	var features uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), lircGetFeatures, uintptr(unsafe.Pointer(&features))); errno != 0 {
+		return false, false, errno
+	}
+	return features&lircCanSendMask != 0, features&lircCanRecMask != 0, nil
+}
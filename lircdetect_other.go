@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+func lircFeatures(path string) (send, receive bool, err error) {
+	return false, false, errors.New("LIRC is only supported on linux")
+}
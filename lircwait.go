@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// waitForLIRC waits until the LIRC device exists, checking up to attempts times every
+// interval. The IR driver may be loaded after the service on boot, and gopi fails to
+// create the app when the device cannot be opened.
+func waitForLIRC(path string, attempts int, interval time.Duration) error {
+	for i := 1; ; i++ {
+		_, err := os.Stat(path)
+		if err == nil {
+			return nil
+		}
+		if i >= attempts {
+			return fmt.Errorf("LIRC device not ready after %d attempts: %v", attempts, err)
+		}
+		log.Printf("waiting for LIRC device %s (%d/%d): %v", path, i, attempts, err)
+		time.Sleep(interval)
+	}
+}
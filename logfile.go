@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/djthorpe/gopi/sys/logger"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// logOutput is the file opened for LOG_FILE, nil when logging to stderr only
+var logOutput *logFile
+
+// logFile is a log file which is rotated to path.<time> once it exceeds maxSize,
+// rotated files older than maxAge are removed
+type logFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mutex sync.Mutex
+	f     *os.File
+	size  int64
+}
+
+func openLogFile(path string, maxSize int64, maxAge time.Duration) (*logFile, error) {
+	l := &logFile{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *logFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, l.path+"."+time.Now().Format("20060102T150405.000")); err != nil {
+		return err
+	}
+	l.removeExpired()
+	return l.open()
+}
+
+// removeExpired removes the rotated files older than maxAge
+func (l *logFile) removeExpired() {
+	if l.maxAge <= 0 {
+		return
+	}
+	rotated, _ := filepath.Glob(l.path + ".*")
+	for _, path := range rotated {
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > l.maxAge {
+			os.Remove(path)
+		}
+	}
+}
+
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return n, err
+}
+
+// Close syncs and closes the file, later writes fail
+func (l *logFile) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	l.f.Sync()
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// openLogOutput opens LOG_FILE and tees the standard logger to it
+func openLogOutput() error {
+	f, err := openLogFile(LogFile, LogFileMaxSize, LogFileMaxAge)
+	if err != nil {
+		return err
+	}
+	logOutput = f
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	return nil
+}
+
+// teeLogger writes the messages of the gopi logger to w as well, with the same
+// level filtering, so that LOG_LEVEL and LogLevelTopic apply to both
+type teeLogger struct {
+	gopi.Logger
+	w io.Writer
+}
+
+func (t *teeLogger) write(level logger.Level, format string, v []interface{}) {
+	if l, ok := t.Logger.(interface {
+		Level() logger.Level
+	}); ok && l.Level() != logger.LOG_ANY && l.Level() > level {
+		return
+	}
+	fmt.Fprintf(t.w, "%s [%v] %s\n", time.Now().Format(time.RFC3339), level, fmt.Sprintf(format, v...))
+}
+
+// SetLevel is forwarded so that setLogLevel keeps working on the wrapped logger
+func (t *teeLogger) SetLevel(level logger.Level) {
+	if l, ok := t.Logger.(interface {
+		SetLevel(logger.Level)
+	}); ok {
+		l.SetLevel(level)
+	}
+}
+
+func (t *teeLogger) Fatal(format string, v ...interface{}) error {
+	t.write(logger.LOG_FATAL, format, v)
+	return t.Logger.Fatal(format, v...)
+}
+
+func (t *teeLogger) Error(format string, v ...interface{}) error {
+	t.write(logger.LOG_ERROR, format, v)
+	return t.Logger.Error(format, v...)
+}
+
+func (t *teeLogger) Warn(format string, v ...interface{}) {
+	t.write(logger.LOG_WARN, format, v)
+	t.Logger.Warn(format, v...)
+}
+
+func (t *teeLogger) Info(format string, v ...interface{}) {
+	t.write(logger.LOG_INFO, format, v)
+	t.Logger.Info(format, v...)
+}
+
+func (t *teeLogger) Debug(format string, v ...interface{}) {
+	t.write(logger.LOG_DEBUG, format, v)
+	t.Logger.Debug(format, v...)
+}
+
+func (t *teeLogger) Debug2(format string, v ...interface{}) {
+	t.write(logger.LOG_DEBUG2, format, v)
+	t.Logger.Debug2(format, v...)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/gopi"
+	"github.com/djthorpe/gopi/sys/logger"
+)
+
+// TestLogFileRotate checks the file is rotated once it exceeds maxSize and that the
+// rotated files older than maxAge are removed
+func TestLogFileRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aircon_ir_emitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "aircon.log")
+
+	expired := path + ".20000101T000000.000"
+	if err := ioutil.WriteFile(expired, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(expired, old, old)
+
+	l, err := openLogFile(path, 20, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, line := range []string{"first line\n", "second line\n"} {
+		if _, err := l.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != "second line\n" {
+		t.Errorf("log %q, %v, want the second line only", b, err)
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 1 || rotated[0] == expired {
+		t.Fatalf("rotated files %v, want the first line only", rotated)
+	}
+	if b, _ := ioutil.ReadFile(rotated[0]); string(b) != "first line\n" {
+		t.Errorf("rotated %q, want the first line", b)
+	}
+
+	l.Close()
+	if _, err := l.Write([]byte("closed\n")); err == nil {
+		t.Error("write after close succeeded")
+	}
+}
+
+// TestTeeLogger checks the file gets the messages of the levels the logger prints,
+// following a change of level
+func TestTeeLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aircon_ir_emitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := gopi.Open(logger.Config{Level: logger.LOG_WARN, Path: filepath.Join(dir, "stderr")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	tee := &teeLogger{Logger: l.(gopi.Logger), w: &b}
+	tee.Info("hidden info")
+	tee.Warn("shown warning")
+	if err := setLogLevel(tee, "debug"); err != nil {
+		t.Fatal(err)
+	}
+	tee.Debug("shown debug")
+
+	out := b.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("filtered message written: %q", out)
+	}
+	for _, want := range []string{"shown warning", "shown debug"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q missing from %q", want, out)
+		}
+	}
+	if n := strings.Count(out, "\n"); n != 2 {
+		t.Errorf("%d lines, want 2", n)
+	}
+}
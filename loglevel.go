@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/djthorpe/gopi/sys/logger"
+	"strings"
+)
+
+var logLevels = map[string]logger.Level{
+	"debug": logger.LOG_DEBUG,
+	"info":  logger.LOG_INFO,
+	"warn":  logger.LOG_WARN,
+	"error": logger.LOG_ERROR,
+}
+
+// setLogLevel changes the level of the gopi logger by name
+func setLogLevel(l gopi.Logger, name string) error {
+	level, ok := logLevels[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return fmt.Errorf("unknown log level: %s (debug, info, warn, error)", name)
+	}
+	setter, ok := l.(interface {
+		SetLevel(logger.Level)
+	})
+	if !ok {
+		return fmt.Errorf("logger does not support changing level")
+	}
+	setter.SetLevel(level)
+	return nil
+}
@@ -1,34 +1,47 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/djthorpe/gopi"
-	_ "github.com/djthorpe/gopi-hw/sys/lirc"
-	_ "github.com/djthorpe/gopi/sys/logger"
-	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/wtks/A75C4269"
 	"log"
-	"net/http"
+	"math/rand"
 	"os"
-	"os/signal"
 	"strconv"
+	"time"
 )
 
 const (
 	ClientID = "rpizerow_aircon"
 	SubTopic = "/aircon/action"
 	PubTopic = "/aircon/state"
-)
+	ErrTopic = "/aircon/error"
+
+	ScheduleTopic     = "/aircon/schedule"
+	ScheduleListTopic = "/aircon/schedule/list"
+	CancelTopic       = "/aircon/cancel"
+
+	RecurringListTopic = "/aircon/schedule/recurring"
+	LogLevelTopic      = "/aircon/loglevel"
+	IdentifyTopic      = "/aircon/identify"
+	VersionTopic       = "/aircon/version"
+	EmitterTopic       = "/aircon/emitter"
+	StatsTopic         = "/aircon/stats"
+	WarningTopic       = "/aircon/warning"
+	ResetTopic         = "/aircon/reset"
+	EnergyTopic        = "/aircon/energy"
 
-var (
-	MQTTHost        = os.Getenv("MQTT_HOST")
-	MQTTUserName    = os.Getenv("MQTT_USERNAME")
-	MQTTPassword    = os.Getenv("MQTT_PASSWORD")
-	SlackWebhookUrl = os.Getenv("SLACK_WEBHOOK")
+	DescribeTopic       = "/aircon/describe"
+	DescribeResultTopic = "/aircon/describe/result"
+
+	DefaultRepublishTopic = "/aircon/republish"
 )
 
+type ErrorMessage struct {
+	Error string `json:"error"`
+}
+
 type Slack struct {
 	Username  string `json:"username,omitempty"`
 	IconEmoji string `json:"icon_emoji,omitempty"`
@@ -36,78 +49,188 @@ type Slack struct {
 }
 
 func main() {
-	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, os.Interrupt, os.Kill)
+	// the status subcommand only reads a running instance, without the config or the hardware
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		if err := runStatus(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitFailure)
+		}
+		return
+	}
+	log.Printf("aircon_ir_emitter %s (%s), remote %s", Version, Commit, RemoteModel)
+	if err := loadConfig(); err != nil {
+		exit(ExitConfig, err.Error())
+	}
+	if len(LogFile) > 0 {
+		if err := openLogOutput(); err != nil {
+			exit(ExitConfig, fmt.Sprintf("LOG_FILE: %v", err))
+		}
+	}
 
-	// init mqtt client
-	mqttOpt := mqtt.NewClientOptions()
-	mqttOpt.AddBroker(MQTTHost)
-	mqttOpt.SetUsername(MQTTUserName)
-	mqttOpt.SetPassword(MQTTPassword)
-	mqttOpt.SetClientID(ClientID)
+	var modules []string
+	// gopi opens /dev/lirc0 unless given the device
+	setDevice := len(os.Getenv("LIRC_DEVICE")) > 0 || len(LIRCDevices) > 0
+	if usesEmitter(EmitterLIRC) || PassiveSync {
+		modules = append(modules, "lirc")
+		if chooseLIRC() {
+			setDevice = true
+		}
+		devices := LIRCDevices
+		if len(devices) == 0 {
+			devices = []string{LIRCDevice}
+		}
+		for _, device := range devices {
+			if err := waitForLIRC(device, LIRCWaitAttempts, LIRCWaitInterval); err != nil {
+				exit(ExitHardware, err.Error())
+			}
+		}
+	}
+	if ButtonPin != gopi.GPIO_PIN_NONE || IdentifyPin != gopi.GPIO_PIN_NONE {
+		modules = append(modules, "gpio")
+	}
+	config := gopi.NewAppConfig(modules...)
+	if setDevice {
+		config.AppFlags.SetString("lirc.device", LIRCDevice)
+	}
+	config.AppFlags.FlagBool("stdin", false, "Emit a single command read from stdin and exit")
+	config.AppFlags.FlagString("replay", "", "Emit the commands of a journal file and exit")
+	config.AppFlags.FlagBool("replay.timing", false, "Keep the original intervals between replayed commands")
+	config.AppFlags.FlagString("commission", "", "Sweep the temperatures of a mode (cool, heat, dry) and exit")
+	config.AppFlags.FlagUint("commission.from", MinTemp, "First temperature of the sweep")
+	config.AppFlags.FlagUint("commission.to", MaxTemp, "Last temperature of the sweep")
+	config.AppFlags.FlagDuration("commission.pause", 5*time.Second, "Pause between the frames of the sweep")
+	config.AppFlags.FlagBool("commission.confirm", false, "Confirm that the sweep sends real IR frames")
 
-	client := mqtt.NewClient(mqttOpt)
-	defer client.Disconnect(250)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+	var result error
+	ret := gopi.CommandLineTool(config, func(app *gopi.AppInstance, done chan<- struct{}) error {
+		result = run(app, done)
+		return result
+	})
+
+	switch {
+	case result != nil:
+		exit(exitCode(result), result.Error())
+	case ret != 0:
+		exit(ExitInit, "failed to create app")
+	default:
+		exit(ExitOK, "stopped")
 	}
+}
 
-	config := gopi.NewAppConfig("lirc")
+// run is the main task of the gopi app
+func run(app *gopi.AppInstance, done chan<- struct{}) error {
+	if usesEmitter(EmitterLIRC) && app.LIRC == nil {
+		return withExitCode(ExitHardware, errors.New("missing LIRC module"))
+	}
 
-	recv := make(chan mqtt.Message)
-	token := client.Subscribe(SubTopic, 0, func(_ mqtt.Client, msg mqtt.Message) {
-		recv <- msg
-	})
-	if token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+	if logOutput != nil {
+		app.Logger = &teeLogger{Logger: app.Logger, w: logOutput}
 	}
 
-	os.Exit(gopi.CommandLineTool(config, func(app *gopi.AppInstance, done chan<- struct{}) error {
+	// -debug and -verbose keep working when LOG_LEVEL is not set
+	if len(LogLevel) > 0 {
+		if err := setLogLevel(app.Logger, LogLevel); err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+	} else if !app.Debug() && !app.Verbose() {
+		setLogLevel(app.Logger, "info")
+	}
+	logConfig(app.Logger)
+
+	if stdin, _ := app.AppFlags.GetBool("stdin"); stdin {
+		return runStdin(app, os.Stdin, os.Stdout)
+	}
+	if path, _ := app.AppFlags.GetString("replay"); len(path) > 0 {
+		timing, _ := app.AppFlags.GetBool("replay.timing")
+		return runReplay(app, path, timing)
+	}
+	if mode, _ := app.AppFlags.GetString("commission"); len(mode) > 0 {
+		from, _ := app.AppFlags.GetUint("commission.from")
+		to, _ := app.AppFlags.GetUint("commission.to")
+		pause, _ := app.AppFlags.GetDuration("commission.pause")
+		confirm, _ := app.AppFlags.GetBool("commission.confirm")
+		return runCommission(app, mode, from, to, pause, confirm)
+	}
+
+	// delay startup randomly so that units rebooting together do not hit the broker at once
+	if StartupJitterMax > 0 {
+		rand.Seed(time.Now().UnixNano())
+		delay := time.Duration(rand.Int63n(int64(StartupJitterMax)))
+		app.Logger.Info("startup jitter: %v", delay)
+		time.Sleep(delay)
+	}
+
+	client, recv, err := connect()
+	if err != nil {
+		return withExitCode(ExitMQTT, err)
+	}
+	defer client.Disconnect(250)
+
+	s := newService(app, client)
+	if len(HTTPAddr) > 0 {
+		ln, err := listenHTTP(s)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		defer ln.Close()
+	}
+	if len(JournalFile) > 0 {
+		if s.journal, err = openJournal(JournalFile, JournalMaxSize); err != nil {
+			return err
+		}
+		defer s.journal.Close()
+	}
+
+	if PassiveSync {
 		if app.LIRC == nil {
-			return errors.New("missing LIRC module")
-		}
-
-		for {
-			select {
-			case <-sigint:
-				done <- gopi.DONE
-				return nil
-			case msg := <-recv:
-				c := A75C4269.Controller{}
-				if err := json.Unmarshal(msg.Payload(), &c); err != nil {
-					app.Logger.Error(err.Error())
-					break
-				}
-
-				if err := app.LIRC.PulseSend(c.GetRawSignal()); err != nil {
-					return err
-				}
-
-				if len(SlackWebhookUrl) > 0 {
-					go func() {
-						err := send(&Slack{
-							Username:  "エアコン",
-							IconEmoji: ":cyclone:",
-							Text:      makeMessage(&c),
-						})
-						if err != nil {
-							app.Logger.Error(err.Error())
-						}
-					}()
-				}
-
-				payload, _ := json.Marshal(c)
-				token := client.Publish(PubTopic, 1, true, string(payload))
-				if token.Wait() && token.Error() != nil {
-					app.Logger.Error(token.Error().Error())
-					break
-				}
-			}
+			return withExitCode(ExitHardware, errors.New("missing LIRC module"))
+		}
+		if s.passive, err = newReceiver(app.LIRC); err != nil {
+			return withExitCode(ExitHardware, err)
+		}
+		defer s.passive.Close()
+	}
+
+	var id *identifier
+	if IdentifyPin != gopi.GPIO_PIN_NONE {
+		if app.GPIO == nil {
+			return withExitCode(ExitHardware, errors.New("missing GPIO module"))
+		}
+		id = newIdentifier(app.Logger, app.GPIO, IdentifyPin)
+	}
+
+	// physical button emitting ButtonCommand, works without the broker
+	var b *button
+	if ButtonPin != gopi.GPIO_PIN_NONE {
+		if app.GPIO == nil {
+			return withExitCode(ExitHardware, errors.New("missing GPIO module"))
+		}
+		if b, err = newButton(app.GPIO, ButtonPin); err != nil {
+			return withExitCode(ExitHardware, err)
 		}
+		defer app.GPIO.Unsubscribe(b.events)
+	}
+
+	// gopi catches SIGINT and SIGTERM itself when the app is created, so shutdown
+	// is driven by its signal only rather than registering another handler
+	stop := make(chan struct{})
+	go func() {
+		app.WaitForSignal()
+		close(stop)
+	}()
+
+	// after a power cut the aircon is put back into the state the broker last saw
+	if RestoreFromStateTopic {
+		if err := s.restoreFromStateTopic(); err != nil {
+			return withExitCode(ExitHardware, err)
+		}
+	}
 
-		done <- gopi.DONE
-		return nil
-	}))
+	if err := s.serve(stop, recv, b, id); err != nil {
+		return withExitCode(ExitHardware, err)
+	}
+	done <- gopi.DONE
+	return nil
 }
 
 func makeMessage(c *A75C4269.Controller) string {
@@ -150,21 +273,3 @@ func makeMessage(c *A75C4269.Controller) string {
 		return "オフ:sleeping:"
 	}
 }
-
-func send(payload *Slack) error {
-	b, _ := json.Marshal(payload)
-
-	req, err := http.NewRequest(http.MethodPost, SlackWebhookUrl, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	return nil
-}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// mqttClientID is ClientID, followed by the host name with MQTTSharedGroup as the
+// instances of a group are connected at once, and by a random suffix with
+// MQTTClientIDRandomSuffix
+func mqttClientID() string {
+	id := ClientID
+	if len(MQTTSharedGroup) > 0 {
+		if host, err := os.Hostname(); err != nil {
+			id = fmt.Sprintf("%s_%d", id, os.Getpid())
+		} else {
+			id += "_" + host
+		}
+	}
+	if MQTTClientIDRandomSuffix {
+		id += "_" + randomSuffix()
+	}
+	return id
+}
+
+// connect connects to the broker and subscribes to the topics handled by the service
+func connect() (mqtt.Client, <-chan mqtt.Message, error) {
+	mqttOpt := mqtt.NewClientOptions()
+	mqttOpt.AddBroker(MQTTHost)
+	mqttOpt.SetUsername(MQTTUserName)
+	mqttOpt.SetPassword(MQTTPassword)
+	id := mqttClientID()
+	mqttOpt.SetClientID(id)
+	// unless pinned, paho tries 3.1.1 then falls back to 3.1
+	switch MQTTVersion {
+	case "3.1":
+		mqttOpt.SetProtocolVersion(3)
+	case "3.1.1":
+		mqttOpt.SetProtocolVersion(4)
+	}
+
+	// exit when auto reconnect does not recover the connection for a long time
+	w := newWatchdog(WatchdogTimeout)
+	t := &takeover{clientID: id}
+	// the loss is published once connected again
+	var mutex sync.Mutex
+	var lostAt time.Time
+	var lostErr error
+	mqttOpt.SetOnConnectHandler(func(c mqtt.Client) {
+		w.connected()
+		t.connected()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !lostAt.IsZero() {
+			publishEvent(c, EventReconnected, map[string]interface{}{
+				"lost_at": lostAt,
+				"error":   lostErr.Error(),
+			})
+			lostAt = time.Time{}
+		}
+	})
+	mqttOpt.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Printf("mqtt connection lost: %v", err)
+		w.lost()
+		t.lost()
+		mutex.Lock()
+		defer mutex.Unlock()
+		lostAt, lostErr = time.Now(), err
+	})
+	if WatchdogTimeout > 0 {
+		go w.run()
+	}
+
+	client := mqtt.NewClient(mqttOpt)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, nil, token.Error()
+	}
+
+	topics := []string{SubTopic, ScheduleTopic, CancelTopic, LogLevelTopic, RepublishTopic, ResetTopic, DescribeTopic}
+	if len(FeedbackTopic) > 0 {
+		topics = append(topics, FeedbackTopic)
+	}
+	if len(AmbientTopic) > 0 {
+		topics = append(topics, AmbientTopic)
+	}
+	if len(PresenceTopic) > 0 {
+		topics = append(topics, PresenceTopic)
+	}
+	if IdentifyPin != gopi.GPIO_PIN_NONE {
+		topics = append(topics, IdentifyTopic)
+	}
+
+	recv := make(chan mqtt.Message)
+	for _, topic := range topics {
+		// with MQTTSharedGroup the broker delivers each command to one of the instances
+		// of the group, the routes of paho strip the prefix to match the topic
+		if topic == SubTopic && len(MQTTSharedGroup) > 0 {
+			topic = "$share/" + MQTTSharedGroup + "/" + SubTopic
+		}
+		token := client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+			if msg.Topic() == SubTopic {
+				commandWaiting()
+			}
+			recv <- msg
+		})
+		if err := waitToken(token, "subscribe "+topic); err != nil {
+			client.Disconnect(250)
+			return nil, nil, err
+		}
+		// 0x80 is the failure return code of SUBACK, as a broker without shared
+		// subscriptions may answer
+		if t, ok := token.(*mqtt.SubscribeToken); ok && t.Result()[topic] == 0x80 {
+			client.Disconnect(250)
+			return nil, nil, fmt.Errorf("subscription to %s rejected by the broker", topic)
+		}
+	}
+	return client, recv, nil
+}
+
+// waitToken waits for token up to MQTTTimeout, so that a broker which accepted the
+// connection but stalls fails the operation of what instead of blocking the loop
+func waitToken(token mqtt.Token, what string) error {
+	if !token.WaitTimeout(MQTTTimeout) {
+		return fmt.Errorf("%s: no reply from the broker within %v", what, MQTTTimeout)
+	}
+	return token.Error()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/wtks/A75C4269"
+)
+
+// testClient connects to broker as id and forwards the messages of topic to the
+// returned channel
+func testClient(t *testing.T, broker *testBroker, id, topic string) (mqtt.Client, <-chan mqtt.Message) {
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker.URL()).SetClientID(id))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	msgs := make(chan mqtt.Message, 16)
+	if token := client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		msgs <- msg
+	}); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return client, msgs
+}
+
+func receive(t *testing.T, msgs <-chan mqtt.Message, what string) mqtt.Message {
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s within 5s", what)
+		return nil
+	}
+}
+
+// testPublishing returns a service publishing to broker, which is driven by the test
+// instead of serve, and the messages it publishes on topic
+func testPublishing(t *testing.T, broker *testBroker, topic string) (s *service, msgs <-chan mqtt.Message, close func()) {
+	client, _ := testClient(t, broker, "aircon", "aircon/test/unused")
+	sub, msgs := testClient(t, broker, "test", topic)
+	return newService(testApp(t), client), msgs, func() {
+		sub.Disconnect(250)
+		client.Disconnect(250)
+	}
+}
+
+// TestMQTTCommand runs the service against the in-process broker: a command published
+// on SubTopic is emitted and its state is published back retained on PubTopic, an
+// invalid one is reported on ErrTopic
+func TestMQTTCommand(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+	host, republish := MQTTHost, RepublishTopic
+	MQTTHost, RepublishTopic = broker.URL(), DefaultRepublishTopic
+	defer func() { MQTTHost, RepublishTopic = host, republish }()
+
+	client, recv, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newService(testApp(t), client)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- s.serve(stop, recv, nil, nil) }()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+	defer client.Disconnect(250)
+
+	tc, states := testClient(t, broker, "test", PubTopic)
+	defer tc.Disconnect(250)
+	ec, errs := testClient(t, broker, "test_errors", ErrTopic)
+	defer ec.Disconnect(250)
+
+	// the command at QoS 1, as sent by most automations
+	if token := tc.Publish(SubTopic, 1, false, `{"power":"on","mode":"cool","temp":26,"fan":"2"}`); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	msg := receive(t, states, "state")
+	got := A75C4269.Controller{}
+	if err := json.Unmarshal(msg.Payload(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume2}
+	if got != want {
+		t.Errorf("state %+v, want %+v", got, want)
+	}
+
+	b, err := ioutil.ReadFile(EmitterFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame := fmt.Sprintf("[% X]", want.GetSignalBytes()); !strings.Contains(string(b), frame) {
+		t.Errorf("emitted %q, want the frame %s", strings.SplitN(string(b), "\n", 2)[0], frame)
+	}
+
+	// a client subscribing later gets the state as retained
+	late, retained := testClient(t, broker, "test_late", PubTopic)
+	defer late.Disconnect(250)
+	msg = receive(t, retained, "retained state")
+	if !msg.Retained() {
+		t.Error("state not retained")
+	}
+	if err := json.Unmarshal(msg.Payload(), &got); err != nil || got != want {
+		t.Errorf("retained state %+v (%v), want %+v", got, err, want)
+	}
+
+	if token := tc.Publish(SubTopic, 1, false, `{"power":"on","mode":"cool","temp":40}`); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	msg = receive(t, errs, "error")
+	e := ErrorMessage{}
+	if err := json.Unmarshal(msg.Payload(), &e); err != nil || len(e.Error) == 0 {
+		t.Errorf("error %s (%v)", msg.Payload(), err)
+	}
+	if msg.Retained() {
+		t.Error("error retained")
+	}
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want 1", n)
+	}
+}
+
+// TestStalledPublish checks a broker which stops replying fails the publishes after
+// MQTTTimeout instead of blocking the loop, which sends and publishes again once the
+// broker recovers
+func TestStalledPublish(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+	timeout := MQTTTimeout
+	MQTTTimeout = 100 * time.Millisecond
+	defer func() { MQTTTimeout = timeout }()
+
+	s, states, close := testPublishing(t, broker, PubTopic)
+	defer close()
+	broker.stall(true)
+	err := waitToken(s.client.Publish(PubTopic, 1, true, "{}"), "publish")
+	if err == nil || !strings.Contains(err.Error(), "publish: no reply from the broker within 100ms") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26}`), sourceMQTT) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command blocked by the stalled broker")
+	}
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want 1 whatever the broker", n)
+	}
+
+	broker.stall(false)
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":25}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	var c A75C4269.Controller
+	if err := json.Unmarshal(receive(t, states, "state after recovery").Payload(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.PresetTemp != 25 {
+		t.Errorf("state %+v, want 25℃", c)
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"/aircon/state", "/aircon/state", true},
+		{"/aircon/state", "/aircon/state/confirmed", false},
+		{"/aircon/+", "/aircon/state", true},
+		{"/aircon/+", "/aircon/state/confirmed", false},
+		{"/aircon/#", "/aircon/state/confirmed", true},
+		{"#", "/aircon/state", true},
+		{"/aircon/state/+", "/aircon/state", false},
+	}
+	for _, tt := range tests {
+		if got := matchTopic(tt.filter, tt.topic); got != tt.match {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.match)
+		}
+	}
+}
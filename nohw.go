@@ -0,0 +1,16 @@
+//go:build !linux || nohw
+// +build !linux nohw
+
+package main
+
+import (
+	"github.com/djthorpe/gopi"
+)
+
+// hardware tells whether the LIRC and GPIO modules of gopi-hw are built in, which
+// the nohw build tag and other platforms than linux leave out
+const hardware = false
+
+func openLIRC(app *gopi.AppInstance, device string) (gopi.LIRC, error) {
+	return nil, errNoHardware
+}
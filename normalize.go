@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"strconv"
+	"strings"
+)
+
+// names accepted for the enum fields of a command, besides their numeric codes
+var (
+	powerValues = map[string]byte{
+		"off":          A75C4269.PowerOff,
+		"on":           A75C4269.PowerOn,
+		"on_off_timer": A75C4269.PowerOnAndOffTimer,
+		"off_on_timer": A75C4269.PowerOffAndOnTimer,
+	}
+	modeValues = map[string]byte{
+		"cool": A75C4269.ModeCooler,
+		"heat": A75C4269.ModeHeater,
+		"dry":  A75C4269.ModeDehumidifier,
+	}
+	airVolumeValues = map[string]byte{
+		"auto":     A75C4269.AirVolumeAuto,
+		"still":    A75C4269.AirVolumeStill,
+		"1":        A75C4269.AirVolume1,
+		"2":        A75C4269.AirVolume2,
+		"3":        A75C4269.AirVolume3,
+		"4":        A75C4269.AirVolume4,
+		"powerful": A75C4269.AirVolumePowerful,
+	}
+	windDirectionValues = map[string]byte{
+		"auto": A75C4269.WindDirectionAuto,
+		"up":   A75C4269.WindDirection1,
+		"1":    A75C4269.WindDirection1,
+		"2":    A75C4269.WindDirection2,
+		"3":    A75C4269.WindDirection3,
+		"4":    A75C4269.WindDirection4,
+		"5":    A75C4269.WindDirection5,
+		"down": A75C4269.WindDirection5,
+	}
+)
+
+// UnmarshalJSON accepts the enum fields of the controller as numeric codes or names,
+// and power also as a bool. fan, swing and temp are accepted as aliases of
+// AirVolume, WindDirection and PresetTemp. temp may be fractional and is rounded to the
+// nearest step of Spec.
+func (cmd *Command) UnmarshalJSON(b []byte) error {
+	type command Command
+	v := struct {
+		command
+		Power         json.RawMessage `json:"power"`
+		Mode          json.RawMessage `json:"mode"`
+		AirVolume     json.RawMessage `json:"airvolume"`
+		WindDirection json.RawMessage `json:"winddirection"`
+		Fan           json.RawMessage `json:"fan"`
+		Swing         json.RawMessage `json:"swing"`
+		Temp          *float64        `json:"temp"`
+	}{command: command(*cmd)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	c := &v.command.Controller
+	// a number is the code of the library in the fields of the controller, which the
+	// native state is published with, and the name in the aliases: fan 2 is the same
+	// speed as "2", not the code 2 of AirVolume1
+	fields := []struct {
+		name   string
+		raw    json.RawMessage
+		values map[string]byte
+		dst    *byte
+		alias  bool
+	}{
+		{"power", v.Power, powerValues, &c.Power, false},
+		{"mode", v.Mode, modeValues, &c.Mode, false},
+		{"airvolume", v.AirVolume, airVolumeValues, &c.AirVolume, false},
+		{"fan", v.Fan, airVolumeValues, &c.AirVolume, true},
+		{"winddirection", v.WindDirection, windDirectionValues, &c.WindDirection, false},
+		{"swing", v.Swing, windDirectionValues, &c.WindDirection, true},
+	}
+	for _, f := range fields {
+		if len(f.raw) == 0 || string(f.raw) == "null" {
+			continue
+		}
+		raw := f.raw
+		var n uint8
+		if f.alias && json.Unmarshal(raw, &n) == nil {
+			raw = json.RawMessage(strconv.Quote(strconv.Itoa(int(n))))
+		}
+		value, err := parseEnum(f.name, raw, f.values)
+		if err != nil {
+			return err
+		}
+		*f.dst = value
+	}
+	if v.Temp != nil {
+		if *v.Temp < 0 {
+			return &fieldError{"temp", fmt.Sprintf("invalid temp: %v", *v.Temp)}
+		}
+		c.PresetTemp = Spec.SnapTemp(c.Mode, *v.Temp)
+	}
+
+	*cmd = Command(v.command)
+	if v.Temp != nil && float64(cmd.PresetTemp) != *v.Temp {
+		cmd.requestedTemp = v.Temp
+	}
+
+	// a command with power only, besides notify, which may restore the previous state
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err == nil {
+		delete(keys, "notify")
+	}
+	if len(keys) == 1 {
+		for k := range keys {
+			cmd.powerOnly = strings.ToLower(k) == "power"
+		}
+	}
+	return nil
+}
+
+// parseEnum returns the value of raw, which is a numeric code, a name in values or,
+// when values has on and off, a bool
+func parseEnum(field string, raw json.RawMessage, values map[string]byte) (byte, error) {
+	var code uint8
+	if err := json.Unmarshal(raw, &code); err == nil {
+		if hasValue(values, code) {
+			return code, nil
+		}
+		return 0, &fieldError{field, fmt.Sprintf("invalid %s: %d", field, code)}
+	}
+
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		name := "off"
+		if b {
+			name = "on"
+		}
+		if v, ok := values[name]; ok {
+			return v, nil
+		}
+	}
+
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		if v, ok := values[strings.ToLower(name)]; ok {
+			return v, nil
+		}
+	}
+
+	return 0, &fieldError{field, fmt.Sprintf("invalid %s: %s (valid: %s)", field, raw, strings.Join(sortedNames(values), ", "))}
+}
+
+// fieldError is an invalid value of a field of a command
+type fieldError struct {
+	field   string
+	message string
+}
+
+func (e *fieldError) Error() string {
+	return e.message
+}
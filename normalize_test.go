@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestCommandUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		want      A75C4269.Controller
+		powerOnly bool
+		err       string
+	}{
+		{"names", `{"power":"on","mode":"cool","airvolume":"powerful","winddirection":"down","temp":26}`,
+			A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, AirVolume: A75C4269.AirVolumePowerful, WindDirection: A75C4269.WindDirection5, PresetTemp: 26}, false, ""},
+		{"library codes", `{"power":1,"mode":2,"airvolume":3,"winddirection":2}`,
+			A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeDehumidifier, AirVolume: A75C4269.AirVolume2, WindDirection: A75C4269.WindDirection2}, false, ""},
+		{"fan and swing numbers are names", `{"fan":2,"swing":5}`,
+			A75C4269.Controller{AirVolume: A75C4269.AirVolume2, WindDirection: A75C4269.WindDirection5}, false, ""},
+		{"case of names", `{"power":"ON","mode":"Heat"}`,
+			A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater}, false, ""},
+		{"bool power", `{"power":false}`,
+			A75C4269.Controller{Power: A75C4269.PowerOff}, true, ""},
+		{"power only with notify", `{"power":true,"notify":false}`,
+			A75C4269.Controller{Power: A75C4269.PowerOn}, true, ""},
+		{"aliases", `{"fan":"2","swing":"up"}`,
+			A75C4269.Controller{AirVolume: A75C4269.AirVolume2, WindDirection: A75C4269.WindDirection1}, false, ""},
+		{"null fields", `{"power":"on","mode":null}`,
+			A75C4269.Controller{Power: A75C4269.PowerOn}, false, ""},
+		{"fractional temp", `{"mode":"cool","temp":22.4}`,
+			A75C4269.Controller{Mode: A75C4269.ModeCooler, PresetTemp: 22}, false, ""},
+		{"invalid code", `{"mode":9}`, A75C4269.Controller{}, false, "invalid mode: 9"},
+		{"invalid fan number", `{"fan":6}`, A75C4269.Controller{}, false, "invalid fan"},
+		{"swing number 0", `{"swing":0}`, A75C4269.Controller{}, false, "invalid swing"},
+		{"invalid name", `{"fan":"turbo"}`, A75C4269.Controller{}, false, "invalid fan"},
+		{"bool mode", `{"mode":true}`, A75C4269.Controller{}, false, "invalid mode"},
+		{"negative temp", `{"temp":-1}`, A75C4269.Controller{}, false, "invalid temp"},
+	}
+	for _, tt := range tests {
+		cmd := Command{}
+		err := json.Unmarshal([]byte(tt.payload), &cmd)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cmd.Controller != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, cmd.Controller, tt.want)
+		}
+		if cmd.powerOnly != tt.powerOnly {
+			t.Errorf("%s: powerOnly %v, want %v", tt.name, cmd.powerOnly, tt.powerOnly)
+		}
+	}
+}
+
+func TestCommandUnmarshalJSONRequestedTemp(t *testing.T) {
+	cmd := Command{}
+	if err := json.Unmarshal([]byte(`{"mode":"cool","temp":22.4}`), &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.requestedTemp == nil || *cmd.requestedTemp != 22.4 {
+		t.Errorf("requestedTemp %v, want 22.4", cmd.requestedTemp)
+	}
+	cmd = Command{}
+	if err := json.Unmarshal([]byte(`{"mode":"cool","temp":22}`), &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.requestedTemp != nil {
+		t.Errorf("requestedTemp %v, want nil for a temp on a step", *cmd.requestedTemp)
+	}
+}
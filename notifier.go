@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// events which can be routed to notifiers with NOTIFY_ROUTES
+const (
+	// EventState is an emitted state
+	EventState = "state"
+	// EventError is an error reported on ErrTopic
+	EventError = "error"
+)
+
+// names of the notifiers
+const (
+	NotifierSlack   = "slack"
+	NotifierDiscord = "discord"
+)
+
+// notifyTimeout bounds a single notification
+const notifyTimeout = 10 * time.Second
+
+// maximum length of a message in runes, within the limits of the webhooks
+var notifyMaxLength = map[string]int{
+	NotifierSlack:   4000,
+	NotifierDiscord: 2000,
+}
+
+// notifier sends a text message to people
+type notifier interface {
+	Name() string
+	Notify(text string) error
+}
+
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) Name() string {
+	return NotifierSlack
+}
+
+func (n *slackNotifier) Notify(text string) error {
+	return send(n.url, &Slack{
+		Username:  unitUsername(),
+		IconEmoji: UnitIcon,
+		Text:      text,
+	})
+}
+
+// Discord is the payload of a Discord webhook
+type Discord struct {
+	Username string `json:"username,omitempty"`
+	Content  string `json:"content"`
+}
+
+type discordNotifier struct {
+	url string
+}
+
+func (n *discordNotifier) Name() string {
+	return NotifierDiscord
+}
+
+func (n *discordNotifier) Notify(text string) error {
+	return send(n.url, &Discord{Username: unitUsername(), Content: text})
+}
+
+// unitUsername is the name notifications are sent as, with UnitName to tell units apart
+func unitUsername() string {
+	if len(UnitName) > 0 {
+		return "エアコン (" + UnitName + ")"
+	}
+	return "エアコン"
+}
+
+// newNotifiers returns the configured notifiers by name
+func newNotifiers() map[string]notifier {
+	notifiers := map[string]notifier{}
+	if len(SlackWebhookUrl) > 0 {
+		notifiers[NotifierSlack] = &slackNotifier{url: SlackWebhookUrl}
+	}
+	if len(DiscordWebhookUrl) > 0 {
+		notifiers[NotifierDiscord] = &discordNotifier{url: DiscordWebhookUrl}
+	}
+	return notifiers
+}
+
+// parseNotifyRoutes parses routes like "state=slack|discord,error=slack"
+func parseNotifyRoutes(v string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, route := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(route), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid route: %s", route)
+		}
+		switch kv[0] {
+		case EventState, EventError:
+		default:
+			return nil, fmt.Errorf("unknown event: %s", kv[0])
+		}
+		for _, name := range strings.Split(kv[1], "|") {
+			switch name {
+			case NotifierSlack, NotifierDiscord:
+			default:
+				return nil, fmt.Errorf("unknown notifier: %s", name)
+			}
+			routes[kv[0]] = append(routes[kv[0]], name)
+		}
+	}
+	return routes, nil
+}
+
+// dispatch sends text to the notifiers routed for event concurrently
+func (s *service) dispatch(event, text string) {
+	names, ok := NotifyRoutes[event]
+	if !ok && event == EventState {
+		// every notifier receives the states unless routed otherwise
+		for name := range s.notifiers {
+			names = append(names, name)
+		}
+	}
+
+	if len(UnitName) > 0 {
+		text = "[" + UnitName + "] " + text
+	}
+	if valid := sanitizeUTF8(text); valid != text {
+		s.app.Logger.Warn("invalid UTF-8 replaced in notification")
+		text = valid
+	}
+
+	for _, name := range names {
+		n, ok := s.notifiers[name]
+		if !ok {
+			continue
+		}
+		text := text
+		if t := truncate(text, notifyMaxLength[name]); t != text {
+			s.app.Logger.Warn("notification to %s truncated to %d characters", name, notifyMaxLength[name])
+			text = t
+		}
+		s.notifying.Add(1)
+		safeGo(s.app.Logger, func() {
+			defer s.notifying.Done()
+			if err := n.Notify(text); err != nil {
+				s.app.Logger.Error("%s: %v", n.Name(), err)
+			}
+		})
+	}
+}
+
+// sanitizeUTF8 replaces invalid UTF-8 sequences in s with U+FFFD
+func sanitizeUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(utf8.RuneError)
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// truncate cuts s to max runes including a trailing ellipsis, max 0 is unlimited
+func truncate(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:max-1]) + "…"
+}
+
+// send posts payload as JSON to url
+func send(url string, payload interface{}) error {
+	b, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: notifyTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+// panicNotifier panics on every notification
+type panicNotifier struct{}
+
+func (panicNotifier) Name() string        { return "panic" }
+func (panicNotifier) Notify(string) error { panic("notifier bug") }
+
+// recordNotifier keeps the notifications it is sent
+type recordNotifier struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (n *recordNotifier) Name() string { return "record" }
+
+func (n *recordNotifier) Notify(text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.texts = append(n.texts, text)
+	return nil
+}
+
+// TestPanickingNotifier checks a notifier panicking does not stop the other notifiers
+// nor the commands which follow
+func TestPanickingNotifier(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+
+	s := newService(testApp(t), nil)
+	record := &recordNotifier{}
+	s.notifiers = map[string]notifier{"panic": panicNotifier{}, "record": record}
+
+	for _, payload := range []string{
+		`{"power":"on","mode":"cool","temp":26}`,
+		`{"power":"on","mode":"cool","temp":25}`,
+	} {
+		if err := s.handleAction([]byte(payload), sourceHTTP); err != nil {
+			t.Fatal(err)
+		}
+		s.notifying.Wait()
+	}
+	if n := emittedFrames(t); n != 2 {
+		t.Errorf("%d frames emitted, want 2", n)
+	}
+	if len(record.texts) != 2 {
+		t.Errorf("%d notifications, want 2", len(record.texts))
+	}
+}
+
+func TestParseNotifyRoutes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string][]string
+		err  string
+	}{
+		{"state=slack|discord,error=slack", map[string][]string{EventState: {NotifierSlack, NotifierDiscord}, EventError: {NotifierSlack}}, ""},
+		{" error=discord", map[string][]string{EventError: {NotifierDiscord}}, ""},
+		{"state", nil, "invalid route"},
+		{"boot=slack", nil, "unknown event"},
+		{"state=line", nil, "unknown notifier"},
+	}
+	for _, tt := range tests {
+		got, err := parseNotifyRoutes(tt.in)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// TestDispatchRoutes checks each event reaches the notifiers it is routed to, the
+// states every notifier and the errors none without a route
+func TestDispatchRoutes(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	routes := NotifyRoutes
+	defer func() { NotifyRoutes = routes }()
+
+	tests := []struct {
+		name           string
+		routes         map[string][]string
+		slack, discord int
+		slackErrors    int
+		discordErrors  int
+	}{
+		{"default", nil, 1, 1, 0, 0},
+		{"routed", map[string][]string{EventState: {NotifierDiscord}, EventError: {NotifierSlack}}, 0, 1, 1, 0},
+		{"errors to both", map[string][]string{EventError: {NotifierSlack, NotifierDiscord}}, 1, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		NotifyRoutes = tt.routes
+		s := newService(testApp(t), nil)
+		slack, discord := &recordNotifier{}, &recordNotifier{}
+		s.notifiers = map[string]notifier{NotifierSlack: slack, NotifierDiscord: discord}
+
+		if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26}`), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		s.fail(errors.New("broken"))
+		s.notifying.Wait()
+
+		count := func(n *recordNotifier) (states, errs int) {
+			for _, text := range n.texts {
+				if strings.Contains(text, "エラー") {
+					errs++
+				} else {
+					states++
+				}
+			}
+			return
+		}
+		slackStates, slackErrors := count(slack)
+		discordStates, discordErrors := count(discord)
+		if slackStates != tt.slack || discordStates != tt.discord {
+			t.Errorf("%s: states sent to slack %d, discord %d, want %d, %d", tt.name, slackStates, discordStates, tt.slack, tt.discord)
+		}
+		if slackErrors != tt.slackErrors || discordErrors != tt.discordErrors {
+			t.Errorf("%s: errors sent to slack %d, discord %d, want %d, %d", tt.name, slackErrors, discordErrors, tt.slackErrors, tt.discordErrors)
+		}
+	}
+}
+
+// TestUnitName checks UnitName and UnitIcon tell the unit apart in the notifications
+func TestUnitName(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	name, icon, slackURL, discordURL := UnitName, UnitIcon, SlackWebhookUrl, DiscordWebhookUrl
+	defer func() { UnitName, UnitIcon, SlackWebhookUrl, DiscordWebhookUrl = name, icon, slackURL, discordURL }()
+
+	var mu sync.Mutex
+	var slack []Slack
+	var discord []Discord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var err error
+		if r.URL.Path == "/slack" {
+			var v Slack
+			err = json.NewDecoder(r.Body).Decode(&v)
+			slack = append(slack, v)
+		} else {
+			var v Discord
+			err = json.NewDecoder(r.Body).Decode(&v)
+			discord = append(discord, v)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	SlackWebhookUrl, DiscordWebhookUrl = srv.URL+"/slack", srv.URL+"/discord"
+
+	tests := []struct {
+		name, icon       string
+		username, prefix string
+	}{
+		{"", "", "エアコン", ""},
+		{"居間", ":couch_and_lamp:", "エアコン (居間)", "[居間] "},
+	}
+	for _, tt := range tests {
+		UnitName, UnitIcon = tt.name, tt.icon
+		slack, discord = nil, nil
+		s := newService(testApp(t), nil)
+		if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26}`), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		s.notifying.Wait()
+
+		mu.Lock()
+		if len(slack) != 1 || slack[0].Username != tt.username || slack[0].IconEmoji != tt.icon || !strings.HasPrefix(slack[0].Text, tt.prefix) {
+			t.Errorf("unit %q: slack got %+v, want %s, %s, text after %q", tt.name, slack, tt.username, tt.icon, tt.prefix)
+		}
+		if len(discord) != 1 || discord[0].Username != tt.username || !strings.HasPrefix(discord[0].Content, tt.prefix) {
+			t.Errorf("unit %q: discord got %+v, want %s, text after %q", tt.name, discord, tt.username, tt.prefix)
+		}
+		if len(tt.prefix) == 0 && len(slack) == 1 && strings.HasPrefix(slack[0].Text, "[") {
+			t.Errorf("unit %q: text %q with a unit prefix", tt.name, slack[0].Text)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestSanitizeUTF8(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"冷房 26℃", "冷房 26℃"},
+		{"a\xffb", "a�b"},
+		{"\xe5\x86", "��"},
+		{"冷\xff房", "冷�房"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeUTF8(tt.in); got != tt.want {
+			t.Errorf("sanitizeUTF8(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"abcdef", 0, "abcdef"},
+		{"abcdef", 6, "abcdef"},
+		{"abcdef", 4, "abc…"},
+		{"冷房運転中です", 4, "冷房運…"},
+		{"ab", 1, "…"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.in, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+// TestNotifyFalse checks a command with notify false is sent without notifying, also
+// when it is coalesced, and that the next command is notified again
+func TestNotifyFalse(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	routes, coalesce, gap := NotifyRoutes, Coalesce, MinSendGap
+	NotifyRoutes = map[string][]string{}
+	defer func() { NotifyRoutes, Coalesce, MinSendGap = routes, coalesce, gap }()
+
+	lastOn := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 22}
+	tests := []struct {
+		name     string
+		payload  string
+		coalesce bool
+		notified bool
+		// temp is the state sent
+		temp uint
+	}{
+		{"default", `{"power":"on","mode":"cool","temp":26}`, false, true, 26},
+		{"notify true", `{"power":"on","mode":"cool","temp":26,"notify":true}`, false, true, 26},
+		{"notify false", `{"power":"on","mode":"cool","temp":26,"notify":false}`, false, false, 26},
+		{"power only", `{"power":"on","notify":false}`, false, false, 22},
+		{"coalesced", `{"power":"on","mode":"cool","temp":25,"notify":false}`, true, false, 25},
+	}
+	for _, tt := range tests {
+		Coalesce, MinSendGap = tt.coalesce, 0
+		if tt.coalesce {
+			MinSendGap = 50 * time.Millisecond
+		}
+		s := newService(testApp(t), nil)
+		s.lastOn = lastOn
+		s.sentAt = time.Now()
+		n := &recordNotifier{}
+		s.notifiers = map[string]notifier{NotifierSlack: n}
+
+		if err := s.handleAction([]byte(tt.payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if tt.coalesce {
+			if s.pending == nil {
+				t.Fatalf("%s: nothing pending", tt.name)
+			}
+			<-s.gap
+			if err := s.flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.notifying.Wait()
+		if s.last == nil || s.last.PresetTemp != tt.temp {
+			t.Errorf("%s: sent %+v, want %d℃", tt.name, s.last, tt.temp)
+		}
+		if notified := len(n.texts) > 0; notified != tt.notified {
+			t.Errorf("%s: notified %v, want %v", tt.name, n.texts, tt.notified)
+		}
+
+		// the flag applies to its command only
+		n.texts = nil
+		if err := s.handleAction([]byte(`{"power":"off"}`), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if tt.coalesce {
+			<-s.gap
+			if err := s.flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.notifying.Wait()
+		if len(n.texts) != 1 {
+			t.Errorf("%s: next command notified %v", tt.name, n.texts)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/wtks/A75C4269"
+	"time"
+)
+
+const (
+	// a space longer than this in microseconds ends a received signal
+	passiveGap = 50000
+	// signals received this soon after our own transmission are its echo
+	passiveEchoWindow = 2 * time.Second
+)
+
+// frame header of the data frame, see spec.md of A75C4269
+var frameHeader = []byte{0x02, 0x20, 0x0E, 0x04, 0x00}
+
+// receiver collects the pulses and spaces received by LIRC into signals
+type receiver struct {
+	lirc   gopi.LIRC
+	events <-chan gopi.Event
+	raw    []uint32
+}
+
+func newReceiver(lirc gopi.LIRC) (*receiver, error) {
+	if lirc.RcvMode() != gopi.LIRC_MODE_MODE2 {
+		if err := lirc.SetRcvMode(gopi.LIRC_MODE_MODE2); err != nil {
+			return nil, err
+		}
+	}
+	return &receiver{lirc: lirc, events: lirc.Subscribe()}, nil
+}
+
+func (r *receiver) Close() {
+	r.lirc.Unsubscribe(r.events)
+}
+
+// add adds evt to the signal being received, it returns the timings of the signal
+// once a long space or a timeout ends it
+func (r *receiver) add(evt gopi.Event) []uint32 {
+	e, ok := evt.(gopi.LIRCEvent)
+	if !ok {
+		return nil
+	}
+	switch e.Type() {
+	case gopi.LIRC_TYPE_PULSE:
+		r.raw = append(r.raw, e.Value())
+	case gopi.LIRC_TYPE_SPACE:
+		// the space before the first pulse is the time since the last signal
+		if len(r.raw) == 0 {
+			return nil
+		}
+		if e.Value() < passiveGap {
+			r.raw = append(r.raw, e.Value())
+			return nil
+		}
+		return r.end()
+	case gopi.LIRC_TYPE_TIMEOUT:
+		return r.end()
+	}
+	return nil
+}
+
+func (r *receiver) end() []uint32 {
+	raw := r.raw
+	r.raw = nil
+	return raw
+}
+
+// decodeSignal returns the state carried by a signal of the real remote and its data frame
+func decodeSignal(raw []uint32) (*A75C4269.Controller, []byte, error) {
+	if err := verifySignal(raw); err != nil {
+		return nil, nil, err
+	}
+	frames, _ := decodeFrames(raw)
+	frame := frames[1]
+	if !bytes.Equal(frame[:len(frameHeader)], frameHeader) {
+		return nil, nil, fmt.Errorf("unknown frame header: % X", frame[:len(frameHeader)])
+	}
+	c, err := decodeFrame(frame)
+	return c, frame, err
+}
+
+// decodeFrame maps the bytes of a data frame back to the fields of the encoder
+func decodeFrame(frame []byte) (*A75C4269.Controller, error) {
+	c := &A75C4269.Controller{}
+
+	switch frame[5] >> 4 {
+	case 0x3:
+		c.Mode = A75C4269.ModeCooler
+	case 0x4:
+		c.Mode = A75C4269.ModeHeater
+	case 0x2:
+		c.Mode = A75C4269.ModeDehumidifier
+	default:
+		return nil, fmt.Errorf("unknown mode: 0x%X", frame[5]>>4)
+	}
+	switch frame[5] & 0xF {
+	case 0x0:
+		c.Power = A75C4269.PowerOff
+	case 0x1:
+		c.Power = A75C4269.PowerOn
+	case 0x5:
+		c.Power = A75C4269.PowerOnAndOffTimer
+	case 0x2:
+		c.Power = A75C4269.PowerOffAndOnTimer
+	default:
+		return nil, fmt.Errorf("unknown power: 0x%X", frame[5]&0xF)
+	}
+
+	c.PresetTemp = uint(frame[6]>>1&0xF) + MinTemp
+
+	switch frame[8] >> 4 {
+	case 0xA:
+		c.AirVolume = A75C4269.AirVolumeAuto
+	case 0x3:
+		switch {
+		case frame[13]&0x20 != 0:
+			c.AirVolume = A75C4269.AirVolumeStill
+		case frame[13]&0x01 != 0:
+			c.AirVolume = A75C4269.AirVolumePowerful
+		default:
+			c.AirVolume = A75C4269.AirVolume1
+		}
+	case 0x4:
+		c.AirVolume = A75C4269.AirVolume2
+	case 0x5:
+		c.AirVolume = A75C4269.AirVolume3
+	case 0x6:
+		c.AirVolume = A75C4269.AirVolume4
+	default:
+		return nil, fmt.Errorf("unknown air volume: 0x%X", frame[8]>>4)
+	}
+	switch d := frame[8] & 0xF; {
+	case d == 0xF:
+		c.WindDirection = A75C4269.WindDirectionAuto
+	case d >= 1 && d <= 5:
+		c.WindDirection = A75C4269.WindDirection1 + d - 1
+	default:
+		return nil, fmt.Errorf("unknown wind direction: 0x%X", d)
+	}
+
+	if c.Power == A75C4269.PowerOnAndOffTimer || c.Power == A75C4269.PowerOffAndOnTimer {
+		for h := byte(1); h <= Spec.MaxTimerHour; h++ {
+			t := *c
+			t.TimerHour = h
+			if b := t.GetSignalBytes(); b[11] == frame[11] && b[12] == frame[12] {
+				c.TimerHour = h
+				break
+			}
+		}
+		if c.TimerHour == 0 {
+			return nil, errors.New("unknown timer hour")
+		}
+	}
+	return c, nil
+}
+
+// handleReceived updates the state from a signal of the real remote, nothing is sent
+func (s *service) handleReceived(raw []uint32) {
+	if time.Since(s.sentAt) < passiveEchoWindow {
+		s.app.Logger.Debug("passive sync: ignored the echo of our own signal")
+		return
+	}
+	c, frame, err := decodeSignal(raw)
+	if err != nil {
+		s.app.Logger.Debug("passive sync: ignored a signal of %d timings: %v", len(raw), err)
+		return
+	}
+	if !bytes.Equal(c.GetSignalBytes(), frame) {
+		s.app.Logger.Debug("passive sync: the signal has bits unknown to the encoder, they are not kept")
+	}
+	if sameState(s.last, c) {
+		s.app.Logger.Debug("passive sync: the remote sent the current state")
+		return
+	}
+	s.app.Logger.Info("passive sync: state changed by the remote: %+v", *c)
+	s.setState(c)
+}
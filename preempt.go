@@ -0,0 +1,22 @@
+package main
+
+import "sync/atomic"
+
+// waitingCommands counts the commands handed to the loop but not received by it yet,
+// a transmission still repeating its frame gives way to them
+var waitingCommands int32
+
+// commandWaiting is called before a command is handed to the loop
+func commandWaiting() {
+	atomic.AddInt32(&waitingCommands, 1)
+}
+
+// commandReceived is called by the loop when it receives a command
+func commandReceived() {
+	atomic.AddInt32(&waitingCommands, -1)
+}
+
+// newerCommand tells whether a command is waiting for the loop
+func newerCommand() bool {
+	return atomic.LoadInt32(&waitingCommands) > 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// preprocess posts the command payload to PreprocessURL, which may return a replacement
+// command, or veto it with a status other than 2xx. When the URL cannot be reached the
+// command is rejected, or passed as is with PreprocessFailOpen.
+func preprocess(payload []byte) ([]byte, error) {
+	client := http.Client{Timeout: PreprocessTimeout}
+	res, err := client.Post(PreprocessURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		if PreprocessFailOpen {
+			return payload, nil
+		}
+		return nil, fmt.Errorf("preprocess: %v", err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		if PreprocessFailOpen {
+			return payload, nil
+		}
+		return nil, fmt.Errorf("preprocess: %v", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("preprocess: vetoed with %s: %s", res.Status, bytes.TrimSpace(b))
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return payload, nil
+	}
+	return b, nil
+}
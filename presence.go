@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"github.com/wtks/A75C4269"
+	"strings"
+	"time"
+)
+
+const (
+	// PresenceReject rejects power on commands while away
+	PresenceReject = "reject"
+	// PresenceDefer keeps the last power on command while away and sends it on return
+	PresenceDefer = "defer"
+)
+
+// presence holds what was last published on PresenceTopic
+type presence struct {
+	// away is set while nobody is home, unknown presence counts as home
+	away bool
+
+	// with PresenceDefer, the power on state held back while away
+	deferred   *A75C4269.Controller
+	deferredAt time.Time
+}
+
+// handlePresence handles home or away on PresenceTopic, a deferred state is sent on
+// return, only LIRC failures are returned
+func (s *service) handlePresence(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "home":
+		s.presence.away = false
+	case "away":
+		s.presence.away = true
+		return nil
+	default:
+		s.app.Logger.Warn("invalid presence: %s (home, away)", payload)
+		return nil
+	}
+
+	c := s.presence.deferred
+	if c == nil {
+		return nil
+	}
+	s.presence.deferred = nil
+	if PresenceDeferMax > 0 && time.Since(s.presence.deferredAt) > PresenceDeferMax {
+		s.app.Logger.Info("presence: deferred state expired after %v", time.Since(s.presence.deferredAt).Truncate(time.Second))
+		return nil
+	}
+	s.app.Logger.Info("presence: sending the deferred state")
+	return s.submit(c)
+}
+
+// holdWhileAway tells whether the power on state c is held back because nobody is home,
+// it is kept to be sent on return with PresenceDefer and reported as an error otherwise
+func (s *service) holdWhileAway(c *A75C4269.Controller) bool {
+	if len(PresenceTopic) == 0 || !s.presence.away {
+		return false
+	}
+	if PresenceMode == PresenceDefer {
+		if s.presence.deferred != nil {
+			s.app.Logger.Debug("presence: superseded deferred state: %+v", *s.presence.deferred)
+		}
+		s.app.Logger.Info("presence: away, power on deferred until home")
+		s.presence.deferred = c
+		s.presence.deferredAt = time.Now()
+		return true
+	}
+	s.app.Logger.Warn("presence: away, power on rejected")
+	s.fail(errors.New("nobody is home, power on rejected"))
+	return true
+}
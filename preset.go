@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"sort"
+)
+
+// Presets are the named states loaded from PRESETS
+var Presets = map[string]A75C4269.Controller{}
+
+// loadPresets parses a JSON object of preset names to plain commands
+func loadPresets(v string) error {
+	var presets map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(v), &presets); err != nil {
+		return err
+	}
+	for name, payload := range presets {
+		cmd := Command{}
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if len(cmd.Action) > 0 || len(cmd.Raw) > 0 || cmd.Boost != nil || len(cmd.Preset) > 0 {
+			return fmt.Errorf("%s: only plain states can be presets", name)
+		}
+		if err := Spec.Check(&cmd.Controller); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		Presets[name] = cmd.Controller
+	}
+	return nil
+}
+
+// lookupPreset returns a copy of the named preset
+func lookupPreset(name string) (*A75C4269.Controller, error) {
+	p, ok := Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset: %s", name)
+	}
+	return &p, nil
+}
+
+// presetNames returns the names of the presets in order
+func presetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// CarrierFrequency is the carrier of the A75C4269 signal in Hz
+	CarrierFrequency = 38000
+	// prontoClock is the period of the Pronto frequency word unit in µs
+	prontoClock = 0.241246
+	// prontoLeadOut is the space appended after the trailer pulse in µs, as Pronto
+	// burst pairs always end with a space
+	prontoLeadOut = 10000
+)
+
+// prontoHex encodes the timings in µs of a signal modulated at freq Hz as a learned
+// Pronto code (0000) sent once: the frequency word, the number of burst pairs once
+// and repeated, then each pulse and space in carrier cycles
+func prontoHex(raw []uint32, freq int) string {
+	word := int(math.Round(1000000 / (float64(freq) * prontoClock)))
+	period := float64(word) * prontoClock
+
+	timings := raw
+	if len(timings)%2 == 1 {
+		timings = append(append([]uint32{}, raw...), prontoLeadOut)
+	}
+
+	words := []string{"0000", fmt.Sprintf("%04X", word), fmt.Sprintf("%04X", len(timings)/2), "0000"}
+	for _, t := range timings {
+		n := int(math.Round(float64(t) / period))
+		if n < 1 {
+			n = 1
+		} else if n > 0xFFFF {
+			n = 0xFFFF
+		}
+		words = append(words, fmt.Sprintf("%04X", n))
+	}
+	return strings.Join(words, " ")
+}
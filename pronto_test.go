@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+// decodePronto returns the carrier frequency and the timings in µs of a learned Pronto
+// code sent once
+func decodePronto(t *testing.T, code string) (float64, []uint32) {
+	var words []uint64
+	for _, w := range strings.Fields(code) {
+		v, err := strconv.ParseUint(w, 16, 16)
+		if err != nil {
+			t.Fatalf("invalid word %q in %s", w, code)
+		}
+		words = append(words, v)
+	}
+	if len(words) < 4 || words[0] != 0 || words[3] != 0 {
+		t.Fatalf("not a learned code sent once: %s", code)
+	}
+	if n := int(words[2]); len(words) != 4+2*n {
+		t.Fatalf("%d words for %d burst pairs", len(words), n)
+	}
+	period := float64(words[1]) * prontoClock
+	raw := make([]uint32, 0, len(words)-4)
+	for _, w := range words[4:] {
+		raw = append(raw, uint32(math.Round(float64(w)*period)))
+	}
+	return 1000000 / period, raw
+}
+
+// TestProntoRoundTrip checks a frame encoded as Pronto decodes to its timings, each
+// within a carrier period, followed by the lead-out space
+func TestProntoRoundTrip(t *testing.T) {
+	c := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume2}
+	raw := c.GetRawSignal()
+	code := prontoHex(raw, CarrierFrequency)
+	if !strings.HasPrefix(code, "0000 006D ") {
+		t.Errorf("code %s..., want the 38kHz word 006D", code[:20])
+	}
+
+	freq, got := decodePronto(t, code)
+	if math.Abs(freq-CarrierFrequency) > 500 {
+		t.Errorf("carrier %.0fHz, want about %dHz", freq, CarrierFrequency)
+	}
+	if len(raw)%2 == 1 {
+		if last := got[len(got)-1]; math.Abs(float64(last)-prontoLeadOut) > 1000000/freq {
+			t.Errorf("lead-out %dµs, want %dµs", last, prontoLeadOut)
+		}
+		got = got[:len(got)-1]
+	}
+	if len(got) != len(raw) {
+		t.Fatalf("%d timings, want %d", len(got), len(raw))
+	}
+	for i := range raw {
+		if math.Abs(float64(got[i])-float64(raw[i])) > 1000000/freq {
+			t.Errorf("timing %d: %dµs, want %dµs", i, got[i], raw[i])
+		}
+	}
+
+	// decoded again, the code does not change
+	if again := prontoHex(got, CarrierFrequency); again != code {
+		t.Errorf("encoded again %s, want %s", again, code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"strings"
+	"time"
+)
+
+// parseReactionDelays parses REACTION_DELAY, a duration for every state or comma
+// separated durations by mode like "cool=30s,heat=1m", with off for powered off states
+func parseReactionDelays(v string) (map[string]time.Duration, error) {
+	delays := make(map[string]time.Duration)
+	if !strings.Contains(v, "=") {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"cool", "heat", "dry", "off"} {
+			delays[name] = d
+		}
+		return delays, nil
+	}
+
+	for _, s := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(s), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid delay: %s", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if _, ok := modeValues[name]; !ok && name != "off" {
+			return nil, fmt.Errorf("invalid mode: %s (cool, heat, dry, off)", kv[0])
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		delays[name] = d
+	}
+	return delays, nil
+}
+
+// reactionDelay returns how long the unit takes to settle into c
+func reactionDelay(c *A75C4269.Controller) time.Duration {
+	if powerName(c.Power) == "off" {
+		return ReactionDelays["off"]
+	}
+	return ReactionDelays[modeName(c.Mode)]
+}
+
+// startTransition marks the state about to be set from c as transitioning until the
+// unit has had time to settle, superseding the transition of a previous state.
+// Nothing is sent when it settles, the state is only published again.
+func (s *service) startTransition(c *A75C4269.Controller) {
+	s.settle = nil
+	s.transitioning = false
+	if d := reactionDelay(c); d > 0 {
+		s.transitioning = true
+		s.settle = time.After(d)
+	}
+}
+
+// settled publishes the state again without the transitioning flag
+func (s *service) settled() {
+	s.settle = nil
+	s.transitioning = false
+	if s.last != nil {
+		s.publishState(s.last)
+	}
+}
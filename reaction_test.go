@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReactionDelays(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]time.Duration
+		err  string
+	}{
+		{"30s", map[string]time.Duration{"cool": 30 * time.Second, "heat": 30 * time.Second, "dry": 30 * time.Second, "off": 30 * time.Second}, ""},
+		{"cool=30s, Heat=1m,off=5s", map[string]time.Duration{"cool": 30 * time.Second, "heat": time.Minute, "off": 5 * time.Second}, ""},
+		{"fan=30s", nil, "invalid mode"},
+		{"cool", nil, "invalid duration"},
+		{"cool=soon", nil, "invalid duration"},
+	}
+	for _, tt := range tests {
+		got, err := parseReactionDelays(tt.in)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// TestTransitioning checks the state is published as transitioning for the delay of
+// its mode, then again once settled
+func TestTransitioning(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+	delays := ReactionDelays
+	ReactionDelays = map[string]time.Duration{"cool": 20 * time.Millisecond}
+	defer func() { ReactionDelays = delays }()
+
+	s, states, close := testPublishing(t, broker, PubTopic)
+	defer close()
+	transitioning := func() bool {
+		v := struct {
+			Transitioning *bool `json:"transitioning"`
+		}{}
+		if err := json.Unmarshal(receive(t, states, "state").Payload(), &v); err != nil || v.Transitioning == nil {
+			t.Fatalf("no transitioning: %v", err)
+		}
+		return *v.Transitioning
+	}
+
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	if !transitioning() {
+		t.Error("not transitioning after the command")
+	}
+	select {
+	case <-s.settle:
+	case <-time.After(time.Second):
+		t.Fatal("not settled")
+	}
+	s.settled()
+	if transitioning() {
+		t.Error("transitioning once settled")
+	}
+
+	// no delay for heat
+	if err := s.handleAction([]byte(`{"power":"on","mode":"heat","temp":22}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	if transitioning() || s.settle != nil {
+		t.Error("transitioning without a delay")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleActionRemoveRecurring removes the recurring rule with the given id
+const ScheduleActionRemoveRecurring = "remove_recurring"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// RecurringRule emits a state at Time in Location on each of Days every week
+type RecurringRule struct {
+	ID         string              `json:"id"`
+	Days       []string            `json:"days"`
+	Time       string              `json:"time"`
+	Controller A75C4269.Controller `json:"controller"`
+}
+
+// Validate checks the days, the time and the state of the rule
+func (r *RecurringRule) Validate() error {
+	if len(r.Days) == 0 {
+		return errors.New("days is required")
+	}
+	for _, d := range r.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid day: %s (valid: %s)", d, strings.Join(sortedDays(), ", "))
+		}
+	}
+	if _, err := time.Parse("15:04", r.Time); err != nil {
+		return fmt.Errorf("invalid time: %s (HH:MM)", r.Time)
+	}
+	return Spec.Check(&r.Controller)
+}
+
+// next returns the first time of the rule after t. The time is taken on the wall clock
+// of Location: on a day when DST skips it, it is moved forward by the skipped hour, and
+// on a day when it occurs twice, it fires once at the second.
+func (r *RecurringRule) next(t time.Time) time.Time {
+	at, _ := time.Parse("15:04", r.Time)
+	t = t.In(Location)
+	for i := 0; i <= 7; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, 0, 0, 0, 0, Location)
+		if !r.on(day.Weekday()) {
+			continue
+		}
+		n := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, Location)
+		if n.After(t) {
+			return n
+		}
+	}
+	return time.Time{}
+}
+
+func (r *RecurringRule) on(d time.Weekday) bool {
+	for _, name := range r.Days {
+		if weekdays[strings.ToLower(name)] == d {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrence returns the scheduled command of the rule at t
+func (r *RecurringRule) occurrence(t time.Time) *ScheduledCommand {
+	return &ScheduledCommand{
+		ID:         r.ID + "@" + t.In(Location).Format("2006-01-02T15:04"),
+		At:         t,
+		Controller: r.Controller,
+		Rule:       r.ID,
+	}
+}
+
+func sortedDays() []string {
+	days := make([]string, 0, len(weekdays))
+	for name := range weekdays {
+		days = append(days, name)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return weekdays[days[i]] < weekdays[days[j]]
+	})
+	return days
+}
+
+// recurring keeps the recurring rules, persisted to path if set. The rules are only
+// changed by the loop but listed over HTTP as well.
+type recurring struct {
+	path  string
+	mutex sync.Mutex
+	rules []*RecurringRule
+}
+
+// recurringPath is where the recurring rules are kept next to ScheduleFile
+func recurringPath() string {
+	if len(ScheduleFile) == 0 {
+		return ""
+	}
+	return ScheduleFile + ".recurring"
+}
+
+func (r *recurring) load() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.path) == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var rules []*RecurringRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return err
+	}
+	r.rules = rules
+	return nil
+}
+
+// save is called with the mutex held
+func (r *recurring) save() error {
+	if len(r.path) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(r.rules)
+	return ioutil.WriteFile(r.path, b, 0644)
+}
+
+func (r *recurring) list() []*RecurringRule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rules := make([]*RecurringRule, len(r.rules))
+	copy(rules, r.rules)
+	return rules
+}
+
+func (r *recurring) get(id string) *RecurringRule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, rule := range r.rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (r *recurring) add(rule *RecurringRule) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(rule.ID) == 0 {
+		rule.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	for _, e := range r.rules {
+		if e.ID == rule.ID {
+			return fmt.Errorf("recurring rule already exists: %s", rule.ID)
+		}
+	}
+	r.rules = append(r.rules, rule)
+	return r.save()
+}
+
+func (r *recurring) remove(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, rule := range r.rules {
+		if rule.ID == id {
+			r.rules = append(r.rules[:i], r.rules[i+1:]...)
+			return r.save()
+		}
+	}
+	return fmt.Errorf("no recurring rule: %s", id)
+}
+
+// planRecurring schedules the next occurrence of each rule which has none pending,
+// after the occurrences in done when they are given, otherwise after now
+func (s *service) planRecurring(done []*ScheduledCommand) {
+	pending := make(map[string]bool)
+	for _, c := range s.schedule.list() {
+		if len(c.Rule) > 0 {
+			pending[c.Rule] = true
+		}
+	}
+	after := make(map[string]time.Time)
+	for _, c := range done {
+		if len(c.Rule) > 0 && c.At.After(after[c.Rule]) {
+			after[c.Rule] = c.At
+		}
+	}
+
+	planned := false
+	for _, rule := range s.recurring.list() {
+		if pending[rule.ID] {
+			continue
+		}
+		t := time.Now()
+		if after[rule.ID].After(t) {
+			t = after[rule.ID]
+		}
+		c := rule.occurrence(rule.next(t))
+		if err := s.schedule.add(c); err != nil {
+			s.app.Logger.Error(err.Error())
+			continue
+		}
+		s.app.Logger.Debug("recurring rule %s: next at %v", rule.ID, c.At.In(Location))
+		planned = true
+	}
+	if planned {
+		s.publishSchedule()
+	}
+}
+
+// addRecurring adds a validated rule and schedules its first occurrence
+func (s *service) addRecurring(rule *RecurringRule) {
+	if err := s.recurring.add(rule); err != nil {
+		s.fail(err)
+		return
+	}
+	s.app.Logger.Info("added recurring rule %s: %s at %s", rule.ID, strings.Join(rule.Days, ","), rule.Time)
+	s.planRecurring(nil)
+	s.publishRecurring()
+}
+
+// removeRecurring removes the rule with id and its pending occurrence
+func (s *service) removeRecurring(id string) {
+	if err := s.recurring.remove(id); err != nil {
+		s.fail(err)
+		return
+	}
+	for _, c := range s.schedule.list() {
+		if c.Rule == id {
+			if _, err := s.schedule.cancel(c.ID); err != nil {
+				s.app.Logger.Error(err.Error())
+			}
+		}
+	}
+	s.app.Logger.Info("removed recurring rule %s", id)
+	s.publishSchedule()
+	s.publishRecurring()
+}
+
+// publishRecurring publishes the recurring rules on RecurringListTopic
+func (s *service) publishRecurring() {
+	payload, _ := json.Marshal(s.recurring.list())
+	if err := s.publish(RecurringListTopic, 1, true, string(payload)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurringRuleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	location := Location
+	Location = berlin
+	defer func() { Location = location }()
+
+	local := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// DST starts at 02:00 on 2026-03-29 and ends at 03:00 on 2026-10-25 in Berlin,
+	// both Sundays
+	tests := []struct {
+		name string
+		rule RecurringRule
+		t    time.Time
+		want time.Time
+	}{
+		{"later today", RecurringRule{Days: []string{"mon"}, Time: "07:00"}, local("2026-03-02 06:00"), local("2026-03-02 07:00")},
+		{"at the time", RecurringRule{Days: []string{"mon"}, Time: "07:00"}, local("2026-03-02 07:00"), local("2026-03-09 07:00")},
+		{"next day of the rule", RecurringRule{Days: []string{"Wed", "fri"}, Time: "18:30"}, local("2026-03-05 12:00"), local("2026-03-06 18:30")},
+		{"wall clock kept into summer time", RecurringRule{Days: []string{"sun"}, Time: "09:00"}, local("2026-03-28 09:00"), utc("2026-03-29 07:00")},
+		{"skipped time moved forward", RecurringRule{Days: []string{"sun"}, Time: "02:30"}, local("2026-03-28 12:00"), utc("2026-03-29 01:30")},
+		{"wall clock kept into winter time", RecurringRule{Days: []string{"sun"}, Time: "09:00"}, local("2026-10-24 09:00"), utc("2026-10-25 08:00")},
+		{"repeated time at its second", RecurringRule{Days: []string{"sun"}, Time: "02:30"}, local("2026-10-24 12:00"), utc("2026-10-25 01:30")},
+		{"not at the first of a repeated time", RecurringRule{Days: []string{"sun"}, Time: "02:30"}, utc("2026-10-25 00:30"), utc("2026-10-25 01:30")},
+		{"repeated time fires once", RecurringRule{Days: []string{"sun"}, Time: "02:30"}, utc("2026-10-25 01:30"), utc("2026-11-01 01:30")},
+		{"no day", RecurringRule{Time: "07:00"}, local("2026-03-02 06:00"), time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.next(tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s: next(%v) = %v, want %v", tt.name, tt.t, got, tt.want.In(berlin))
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/djthorpe/gopi"
+	"time"
+)
+
+// runReplay re-emits the commands of a journal file without connecting to the broker,
+// with timing the original intervals between commands are kept
+func runReplay(app *gopi.AppInstance, path string, timing bool) error {
+	entries, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+
+	s := newService(app, nil)
+	defer s.notifying.Wait()
+
+	for i, e := range entries {
+		if timing && i > 0 {
+			time.Sleep(e.Time.Sub(entries[i-1].Time))
+		}
+		app.Logger.Info("replaying command %d/%d from %v", i+1, len(entries), e.Time)
+		if err := s.handleAction(e.Command, sourceReplay); err != nil {
+			return err
+		}
+		if s.pending != nil {
+			<-s.gap
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/wtks/A75C4269"
+	"time"
+)
+
+// RestoreWait is how long the retained state on PubTopic is waited for at startup
+const RestoreWait = 3 * time.Second
+
+// readRetainedState returns the state retained on PubTopic, nil if there is none.
+//
+// PubTopic is subscribed only while waiting and unsubscribed before returning, and
+// only a message with the retained flag is taken. The broker sets it only on what it
+// replays to a new subscription, so the state published by emit afterwards, or a
+// live message published while waiting, never comes back as a command.
+func readRetainedState(client mqtt.Client) (*A75C4269.Controller, error) {
+	retained := make(chan []byte, 1)
+	token := client.Subscribe(PubTopic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		if !msg.Retained() {
+			return
+		}
+		select {
+		case retained <- msg.Payload():
+		default:
+		}
+	})
+	if err := waitToken(token, "subscribe "+PubTopic); err != nil {
+		return nil, err
+	}
+	defer waitToken(client.Unsubscribe(PubTopic), "unsubscribe "+PubTopic)
+
+	var payload []byte
+	select {
+	case payload = <-retained:
+	case <-time.After(RestoreWait):
+		return nil, nil
+	}
+
+	// the state is published as a controller, which also decodes as a command
+	cmd := Command{}
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, err
+	}
+	if len(cmd.Action) > 0 || len(cmd.Preset) > 0 || len(cmd.Raw) > 0 || cmd.Boost != nil || cmd.Setpoint != nil {
+		return nil, errors.New("retained state is a command, not a state")
+	}
+	c := cmd.Controller
+	if err := Spec.Check(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// restoreFromStateTopic emits the state retained on PubTopic so that the aircon
+// matches the last reported state after a power cut, only LIRC failures are returned
+func (s *service) restoreFromStateTopic() error {
+	c, err := readRetainedState(s.client)
+	if err != nil {
+		s.app.Logger.Warn("restore from %s: %v", PubTopic, err)
+		return nil
+	}
+	if c == nil {
+		s.app.Logger.Info("restore from %s: no retained state", PubTopic)
+		return nil
+	}
+	s.app.Logger.Info("restoring the retained state: %+v", *c)
+	s.source = sourceRestore
+	return s.submit(c)
+}
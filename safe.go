@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/djthorpe/gopi"
+	"runtime/debug"
+)
+
+// safeGo runs f in a goroutine, a panic in it is logged with its stack
+// instead of taking the whole process down.
+//
+// A few goroutines are started with a bare go on purpose, as recovering there would
+// leave the process running in a broken state rather than restarted by its supervisor:
+//   - the watchdog of connect, which exists to exit when the broker is gone
+//   - the WaitForSignal of main, without which stop is never closed and SIGTERM is lost
+//   - http.Serve of listenHTTP, net/http already recovers the panics of each handler
+//     and the API would otherwise stop answering silently
+//   - the waitToken of publishEvent, which only waits on a paho token and logs with the
+//     log package, as events are published from the connect handlers of paho too
+func safeGo(l gopi.Logger, f func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				l.Error("panic: %v\n%s", r, debug.Stack())
+			}
+		}()
+		f()
+	}()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"sort"
+	"strings"
+)
+
+// actions of SanityRules
+const (
+	SanityOff    = "off"
+	SanityWarn   = "warn"
+	SanityReject = "reject"
+)
+
+// sanityRule finds a state which makes no sense to the unit, check returns why or ""
+type sanityRule struct {
+	name string
+	// action is the action of the rule unless SANITY_RULES sets it
+	action string
+	check  func(prev, c *A75C4269.Controller, ambient, outside *float64) string
+}
+
+// sanityRules are checked in order on the state of a command, see SanityRules:
+//
+//	cool_above_ambient  cooling to a temperature at or above the ambient one
+//	heat_below_ambient  heating to a temperature at or below the ambient one
+//	mode_and_temp       changing the mode and the temperature of a running unit at once
+//	heat_warm_outside   heating at an outside temperature at or above OutsideNoHeatAbove
+//	cool_cold_outside   cooling at an outside temperature at or below OutsideNoCoolBelow
+//
+// mode_and_temp is off unless enabled: every frame carries the whole state, so a mode
+// change comes with the temperature of the new mode on most commands, and only some
+// models reset the temperature when the mode changes.
+var sanityRules = []sanityRule{
+	{"cool_above_ambient", SanityWarn, func(prev, c *A75C4269.Controller, ambient, outside *float64) string {
+		if ambient == nil || powerName(c.Power) != "on" || c.Mode != A75C4269.ModeCooler || float64(c.PresetTemp) < *ambient {
+			return ""
+		}
+		return fmt.Sprintf("cooling to %d℃ does nothing at an ambient %.1f℃", c.PresetTemp, *ambient)
+	}},
+	{"heat_below_ambient", SanityWarn, func(prev, c *A75C4269.Controller, ambient, outside *float64) string {
+		if ambient == nil || powerName(c.Power) != "on" || c.Mode != A75C4269.ModeHeater || float64(c.PresetTemp) > *ambient {
+			return ""
+		}
+		return fmt.Sprintf("heating to %d℃ does nothing at an ambient %.1f℃", c.PresetTemp, *ambient)
+	}},
+	{"mode_and_temp", SanityOff, func(prev, c *A75C4269.Controller, ambient, outside *float64) string {
+		if prev == nil || powerName(prev.Power) != "on" || powerName(c.Power) != "on" || prev.Mode == c.Mode || prev.PresetTemp == c.PresetTemp {
+			return ""
+		}
+		return fmt.Sprintf("mode %s to %s and temperature %d℃ to %d℃ changed at once, send the mode then the temperature",
+			modeName(prev.Mode), modeName(c.Mode), prev.PresetTemp, c.PresetTemp)
+	}},
+	{"heat_warm_outside", SanityWarn, func(prev, c *A75C4269.Controller, ambient, outside *float64) string {
+		if outside == nil || powerName(c.Power) != "on" || c.Mode != A75C4269.ModeHeater || *outside < OutsideNoHeatAbove {
+			return ""
+		}
+		return fmt.Sprintf("heating while it is %.1f℃ outside", *outside)
+	}},
+	{"cool_cold_outside", SanityWarn, func(prev, c *A75C4269.Controller, ambient, outside *float64) string {
+		if outside == nil || powerName(c.Power) != "on" || c.Mode != A75C4269.ModeCooler || *outside > OutsideNoCoolBelow {
+			return ""
+		}
+		return fmt.Sprintf("cooling while it is %.1f℃ outside", *outside)
+	}},
+}
+
+// parseSanityRules parses SANITY_RULES, a list of rule=off|warn|reject over the
+// default action of each rule
+func parseSanityRules(v string) (map[string]string, error) {
+	rules := make(map[string]string)
+	for _, r := range sanityRules {
+		rules[r.name] = r.action
+	}
+	if len(strings.TrimSpace(v)) == 0 {
+		return rules, nil
+	}
+	for _, s := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(s), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule: %s", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if _, ok := rules[name]; !ok {
+			return nil, fmt.Errorf("unknown rule: %s (valid: %s)", kv[0], strings.Join(sanityRuleNames(), ", "))
+		}
+		switch action := strings.ToLower(strings.TrimSpace(kv[1])); action {
+		case SanityOff, SanityWarn, SanityReject:
+			rules[name] = action
+		default:
+			return nil, fmt.Errorf("invalid action of %s: %s (off, warn, reject)", name, kv[1])
+		}
+	}
+	return rules, nil
+}
+
+func sanityRuleNames() []string {
+	names := make([]string, 0, len(sanityRules))
+	for _, r := range sanityRules {
+		names = append(names, r.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sanityTransform checks the state against sanityRules with the latest ambient and
+// outside temperatures, logging a warning or rejecting the state as SanityRules says
+func (s *service) sanityTransform(prev, in *A75C4269.Controller) (*A75C4269.Controller, error) {
+	outside := s.weather.outside()
+	for _, r := range sanityRules {
+		action := SanityRules[r.name]
+		if action == SanityOff || len(action) == 0 {
+			continue
+		}
+		reason := r.check(prev, in, s.ambient, outside)
+		if len(reason) == 0 {
+			continue
+		}
+		if action == SanityReject {
+			return nil, fmt.Errorf("rejected by %s: %s", r.name, reason)
+		}
+		s.app.Logger.Warn("%s: %s", r.name, reason)
+		s.event(EventSanityWarning, map[string]interface{}{"rule": r.name, "reason": reason})
+	}
+	return in, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestParseSanityRules(t *testing.T) {
+	defaults := map[string]string{
+		"cool_above_ambient": SanityWarn,
+		"heat_below_ambient": SanityWarn,
+		"mode_and_temp":      SanityOff,
+		"heat_warm_outside":  SanityWarn,
+		"cool_cold_outside":  SanityWarn,
+	}
+	with := func(changes map[string]string) map[string]string {
+		rules := map[string]string{}
+		for name, action := range defaults {
+			rules[name] = action
+		}
+		for name, action := range changes {
+			rules[name] = action
+		}
+		return rules
+	}
+	tests := []struct {
+		v    string
+		want map[string]string
+		err  string
+	}{
+		{"", defaults, ""},
+		{" ", defaults, ""},
+		{"cool_above_ambient=reject", with(map[string]string{"cool_above_ambient": SanityReject}), ""},
+		{" Mode_And_Temp = WARN , heat_warm_outside=off", with(map[string]string{"mode_and_temp": SanityWarn, "heat_warm_outside": SanityOff}), ""},
+		{"cool_above_ambient", nil, "invalid rule"},
+		{"no_such_rule=warn", nil, "unknown rule: no_such_rule"},
+		{"mode_and_temp=drop", nil, "invalid action of mode_and_temp: drop"},
+	}
+	for _, tt := range tests {
+		got, err := parseSanityRules(tt.v)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got %v, want %q", tt.v, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.v, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.v, got, tt.want)
+			continue
+		}
+		for name, action := range tt.want {
+			if got[name] != action {
+				t.Errorf("%q: %s is %q, want %q", tt.v, name, got[name], action)
+			}
+		}
+	}
+}
+
+func TestSanityRules(t *testing.T) {
+	temp := func(v float64) *float64 { return &v }
+	cool := func(t uint) *A75C4269.Controller {
+		return &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: t}
+	}
+	heat := func(t uint) *A75C4269.Controller {
+		return &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: t}
+	}
+	off := &A75C4269.Controller{Power: A75C4269.PowerOff, Mode: A75C4269.ModeCooler, PresetTemp: 30}
+
+	tests := []struct {
+		name             string
+		prev, c          *A75C4269.Controller
+		ambient, outside *float64
+		// rules firing
+		want []string
+	}{
+		{"nothing known", nil, cool(26), nil, nil, nil},
+		{"cooling below ambient", nil, cool(26), temp(28), nil, nil},
+		{"cooling at ambient", nil, cool(26), temp(26), nil, []string{"cool_above_ambient"}},
+		{"cooling above ambient", nil, cool(28), temp(25.5), nil, []string{"cool_above_ambient"}},
+		{"heating above ambient", nil, heat(22), temp(19), nil, nil},
+		{"heating below ambient", nil, heat(20), temp(21), nil, []string{"heat_below_ambient"}},
+		{"power off", nil, off, temp(20), temp(35), nil},
+		{"mode and temp at once", heat(22), cool(26), nil, nil, []string{"mode_and_temp"}},
+		{"mode only", heat(26), cool(26), nil, nil, nil},
+		{"temp only", cool(26), cool(25), nil, nil, nil},
+		{"mode and temp from off", off, heat(22), nil, nil, nil},
+		{"heating warm outside", nil, heat(22), nil, temp(20), []string{"heat_warm_outside"}},
+		{"heating cold outside", nil, heat(22), nil, temp(5), nil},
+		{"cooling cold outside", nil, cool(26), nil, temp(15), []string{"cool_cold_outside"}},
+		{"cooling hot outside", nil, cool(26), nil, temp(30), nil},
+		{"several", heat(22), cool(26), temp(24), temp(10), []string{"cool_above_ambient", "mode_and_temp", "cool_cold_outside"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range sanityRules {
+			if reason := r.check(tt.prev, tt.c, tt.ambient, tt.outside); len(reason) > 0 {
+				got = append(got, r.name)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanityTransform(t *testing.T) {
+	rules := SanityRules
+	defer func() { SanityRules = rules }()
+
+	s := newService(testApp(t), nil)
+	ambient := 24.0
+	s.ambient = &ambient
+	c := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+
+	tests := []struct {
+		action string
+		err    string
+	}{
+		{SanityOff, ""},
+		{SanityWarn, ""},
+		{SanityReject, "rejected by cool_above_ambient"},
+	}
+	for _, tt := range tests {
+		SanityRules = map[string]string{"cool_above_ambient": tt.action}
+		got, err := s.sanityTransform(nil, c)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.action, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != c {
+			t.Errorf("%s: got %+v, %v, want the state unchanged", tt.action, got, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// TagBoost marks the settle step of a boost command
+	TagBoost = "boost"
+	// TagSleepCurve marks the steps of a sleep curve command
+	TagSleepCurve = "sleep_curve"
+	// TagPowerfulRevert marks the state update when the unit leaves powerful mode, nothing is sent for it
+	TagPowerfulRevert = "powerful_revert"
+)
+
+const (
+	// ScheduleActionList republishes the scheduled commands on ScheduleListTopic
+	ScheduleActionList = "list"
+	// ScheduleActionCancel removes the scheduled command with the given id
+	ScheduleActionCancel = "cancel"
+)
+
+// ScheduledCommand is a state to be emitted at an absolute time
+type ScheduledCommand struct {
+	ID         string              `json:"id"`
+	At         time.Time           `json:"at"`
+	Controller A75C4269.Controller `json:"controller"`
+	// Tag is set on commands scheduled internally, empty for user schedules
+	Tag string `json:"tag,omitempty"`
+	// Rule is the id of the RecurringRule this is an occurrence of
+	Rule string `json:"rule,omitempty"`
+}
+
+// ScheduleRequest is a message received on ScheduleTopic
+type ScheduleRequest struct {
+	Action     string               `json:"action,omitempty"`
+	ID         string               `json:"id,omitempty"`
+	At         LocalTime            `json:"at"`
+	Controller *A75C4269.Controller `json:"controller,omitempty"`
+	// Recurring adds a weekly rule instead of a single command
+	Recurring *RecurringRule `json:"recurring,omitempty"`
+}
+
+// CancelRequest is a message received on CancelTopic, an empty request cancels every
+// scheduled command
+type CancelRequest struct {
+	ID  string `json:"id,omitempty"`
+	Tag string `json:"tag,omitempty"`
+}
+
+// scheduler keeps scheduled commands ordered by time and fires its timer at the earliest one
+type scheduler struct {
+	path     string
+	commands []*ScheduledCommand
+	timer    *time.Timer
+}
+
+// scheduleView is the copy of the scheduled commands read by the HTTP API, updated
+// whenever they are published
+type scheduleView struct {
+	mu       sync.Mutex
+	commands []*ScheduledCommand
+}
+
+func (v *scheduleView) set(commands []*ScheduledCommand) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.commands = commands
+}
+
+func (v *scheduleView) snapshot() []*ScheduledCommand {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	commands := make([]*ScheduledCommand, len(v.commands))
+	copy(commands, v.commands)
+	return commands
+}
+
+func newScheduler(path string) *scheduler {
+	t := time.NewTimer(0)
+	if !t.Stop() {
+		<-t.C
+	}
+	return &scheduler{path: path, timer: t}
+}
+
+// C is the channel which receives when the earliest command is due
+func (s *scheduler) C() <-chan time.Time {
+	return s.timer.C
+}
+
+// load reads persisted commands, returns the ones which are already past and have been discarded
+func (s *scheduler) load() ([]*ScheduledCommand, error) {
+	if len(s.path) == 0 {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var commands []*ScheduledCommand
+	if err := json.Unmarshal(b, &commands); err != nil {
+		return nil, err
+	}
+
+	var past []*ScheduledCommand
+	now := time.Now()
+	for _, c := range commands {
+		if c.At.Before(now) {
+			past = append(past, c)
+			continue
+		}
+		s.commands = append(s.commands, c)
+	}
+	s.sort()
+	return past, s.save()
+}
+
+func (s *scheduler) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(s.list())
+	return ioutil.WriteFile(s.path, b, 0644)
+}
+
+func (s *scheduler) sort() {
+	sort.SliceStable(s.commands, func(i, j int) bool {
+		return s.commands[i].At.Before(s.commands[j].At)
+	})
+
+	if !s.timer.Stop() {
+		select {
+		case <-s.timer.C:
+		default:
+		}
+	}
+	if len(s.commands) > 0 {
+		s.timer.Reset(time.Until(s.commands[0].At))
+	}
+}
+
+func (s *scheduler) list() []*ScheduledCommand {
+	commands := make([]*ScheduledCommand, len(s.commands))
+	copy(commands, s.commands)
+	return commands
+}
+
+func (s *scheduler) add(c *ScheduledCommand) error {
+	if c.At.Before(time.Now()) {
+		return fmt.Errorf("scheduled time is in the past: %v", c.At.In(Location))
+	}
+	if len(c.ID) == 0 {
+		c.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	for _, e := range s.commands {
+		if e.ID == c.ID {
+			return fmt.Errorf("scheduled command already exists: %s", c.ID)
+		}
+	}
+
+	s.commands = append(s.commands, c)
+	s.sort()
+	return s.save()
+}
+
+// cancel removes and returns the command with id
+func (s *scheduler) cancel(id string) (*ScheduledCommand, error) {
+	for i, c := range s.commands {
+		if c.ID == id {
+			s.commands = append(s.commands[:i], s.commands[i+1:]...)
+			s.sort()
+			return c, s.save()
+		}
+	}
+	return nil, fmt.Errorf("no scheduled command: %s", id)
+}
+
+// cancelMatching removes and returns the commands with the id and tag of r, fields of r
+// which are empty match any command. It fails when an id is given and nothing matches.
+func (s *scheduler) cancelMatching(r *CancelRequest) ([]*ScheduledCommand, error) {
+	var cancelled []*ScheduledCommand
+	commands := s.commands[:0]
+	for _, c := range s.commands {
+		if (len(r.ID) == 0 || c.ID == r.ID) && (len(r.Tag) == 0 || c.Tag == r.Tag) {
+			cancelled = append(cancelled, c)
+		} else {
+			commands = append(commands, c)
+		}
+	}
+	if len(cancelled) == 0 {
+		if len(r.ID) > 0 {
+			return nil, fmt.Errorf("no scheduled command: %s", r.ID)
+		}
+		return nil, nil
+	}
+	s.commands = commands
+	s.sort()
+	return cancelled, s.save()
+}
+
+// Conflicts between scheduled and immediate commands are resolved as follows:
+//   - an immediate command (action topic, button, HTTP) cancels every internal step
+//     (boost settle, sleep curve steps), which would otherwise undo it later
+//   - a user schedule cancels the internal steps when it fires, as if it was sent then
+//   - user schedules are never cancelled implicitly, only by ScheduleActionCancel or CancelTopic
+//   - internal steps cancel nothing when they fire
+//   - occurrences of a recurring rule are user schedules, cancelling one skips it and
+//     the next one is scheduled
+
+// supersedesInternal tells whether firing c cancels the internal steps
+func supersedesInternal(c *ScheduledCommand) bool {
+	return len(c.Tag) == 0
+}
+
+// cancelInternal removes and returns the commands scheduled internally, which have a tag
+func (s *scheduler) cancelInternal() ([]*ScheduledCommand, error) {
+	var cancelled []*ScheduledCommand
+	commands := s.commands[:0]
+	for _, c := range s.commands {
+		if len(c.Tag) > 0 {
+			cancelled = append(cancelled, c)
+		} else {
+			commands = append(commands, c)
+		}
+	}
+	if len(cancelled) == 0 {
+		return nil, nil
+	}
+	s.commands = commands
+	s.sort()
+	return cancelled, s.save()
+}
+
+// due removes and returns the commands whose time has come
+func (s *scheduler) due() ([]*ScheduledCommand, error) {
+	now := time.Now()
+	i := 0
+	for i < len(s.commands) && !s.commands[i].At.After(now) {
+		i++
+	}
+	due := s.commands[:i:i]
+	s.commands = s.commands[i:]
+	s.sort()
+	return due, s.save()
+}
+
+// Validate checks that the request can be applied to a scheduler
+func (r *ScheduleRequest) Validate() error {
+	switch r.Action {
+	case "":
+		if r.Recurring != nil {
+			return r.Recurring.Validate()
+		}
+		if r.At.IsZero() {
+			return errors.New("at is required")
+		}
+		if r.Controller == nil {
+			return errors.New("controller is required")
+		}
+	case ScheduleActionList:
+	case ScheduleActionCancel, ScheduleActionRemoveRecurring:
+		if len(r.ID) == 0 {
+			return errors.New("id is required")
+		}
+	default:
+		return fmt.Errorf("unknown schedule action: %s", r.Action)
+	}
+	return nil
+}
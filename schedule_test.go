@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelMatching(t *testing.T) {
+	tests := []struct {
+		name      string
+		r         CancelRequest
+		cancelled []string
+		err       string
+	}{
+		{"everything", CancelRequest{}, []string{"user", "boost", "curve1", "curve2"}, ""},
+		{"by id", CancelRequest{ID: "boost"}, []string{"boost"}, ""},
+		{"by tag", CancelRequest{Tag: TagSleepCurve}, []string{"curve1", "curve2"}, ""},
+		{"by id and tag", CancelRequest{ID: "curve2", Tag: TagSleepCurve}, []string{"curve2"}, ""},
+		{"id of another tag", CancelRequest{ID: "user", Tag: TagBoost}, nil, "no scheduled command: user"},
+		{"unknown id", CancelRequest{ID: "none"}, nil, "no scheduled command: none"},
+		{"unknown tag", CancelRequest{Tag: "none"}, nil, ""},
+	}
+	for _, tt := range tests {
+		s := newScheduler("")
+		now := time.Now()
+		for i, c := range []*ScheduledCommand{
+			{ID: "curve2", Tag: TagSleepCurve},
+			{ID: "user"},
+			{ID: "boost", Tag: TagBoost},
+			{ID: "curve1", Tag: TagSleepCurve},
+		} {
+			c.At = now.Add(time.Duration(i+1) * time.Hour)
+			if err := s.add(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cancelled, err := s.cancelMatching(&tt.r)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+
+		got := map[string]bool{}
+		for _, c := range cancelled {
+			got[c.ID] = true
+		}
+		if len(got) != len(tt.cancelled) {
+			t.Errorf("%s: cancelled %v, want %v", tt.name, ids(cancelled), tt.cancelled)
+		}
+		for _, id := range tt.cancelled {
+			if !got[id] {
+				t.Errorf("%s: cancelled %v, want %v", tt.name, ids(cancelled), tt.cancelled)
+				break
+			}
+		}
+		if n := len(s.list()); n != 4-len(tt.cancelled) {
+			t.Errorf("%s: %d commands left, want %d", tt.name, n, 4-len(tt.cancelled))
+		}
+		for i := 1; i < len(s.commands); i++ {
+			if s.commands[i].At.Before(s.commands[i-1].At) {
+				t.Errorf("%s: commands left out of order: %v", tt.name, ids(s.commands))
+			}
+		}
+	}
+}
+
+func ids(commands []*ScheduledCommand) []string {
+	var ids []string
+	for _, c := range commands {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// loadSecretFiles overrides secrets with the contents of the files given by
+// the corresponding *_FILE variables, e.g. Docker or Kubernetes secrets
+func loadSecretFiles() error {
+	secrets := []struct {
+		env   string
+		value *string
+	}{
+		{"MQTT_USERNAME_FILE", &MQTTUserName},
+		{"MQTT_PASSWORD_FILE", &MQTTPassword},
+		{"SLACK_WEBHOOK_FILE", &SlackWebhookUrl},
+		{"DISCORD_WEBHOOK_FILE", &DiscordWebhookUrl},
+		{"INFLUX_TOKEN_FILE", &InfluxToken},
+		{"WEATHER_API_KEY_FILE", &WeatherKey},
+	}
+	for _, s := range secrets {
+		path := os.Getenv(s.env)
+		if len(path) == 0 {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.env, err)
+		}
+		*s.value = strings.TrimRight(string(b), "\r\n")
+	}
+	return nil
+}
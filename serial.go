@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serialEmitter sends the timings to a microcontroller such as an Arduino driving the IR
+// LED, over a serial port with a line protocol:
+//
+//	SEND <seq> <carrier Hz> <t1>,<t2>,...\n   to the microcontroller, timings in µs
+//	OK <seq>\n                                when the frame was sent
+//	ERR <seq> <message>\n                     when it was not
+//
+// Other lines, such as replies to a previous frame which timed out, are skipped. The
+// port is opened again on the next frame after a failure, as when the USB cable is
+// unplugged and plugged back.
+type serialEmitter struct {
+	open    func() (io.ReadWriteCloser, error)
+	port    io.ReadWriteCloser
+	timeout time.Duration
+	seq     uint32
+	buf     []byte
+}
+
+func (e *serialEmitter) Name() string {
+	return EmitterSerial
+}
+
+func (e *serialEmitter) Send(raw []uint32) error {
+	if e.port == nil {
+		port, err := e.open()
+		if err != nil {
+			return fmt.Errorf("serial emitter: %v", err)
+		}
+		e.port, e.buf = port, nil
+	}
+	if err := e.send(raw); err != nil {
+		// the port is fine when the microcontroller replied
+		if _, ok := err.(*serialNack); !ok {
+			e.port.Close()
+			e.port = nil
+		}
+		return fmt.Errorf("serial emitter: %v", err)
+	}
+	return nil
+}
+
+// serialNack is an ERR reply of the microcontroller
+type serialNack struct {
+	message string
+}
+
+func (e *serialNack) Error() string {
+	return "microcontroller: " + e.message
+}
+
+func (e *serialEmitter) send(raw []uint32) error {
+	e.seq++
+	seq := strconv.FormatUint(uint64(e.seq), 10)
+	timings := make([]string, len(raw))
+	for i, t := range raw {
+		timings[i] = strconv.FormatUint(uint64(t), 10)
+	}
+	if _, err := fmt.Fprintf(e.port, "SEND %s %d %s\n", seq, CarrierFrequency, strings.Join(timings, ",")); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(e.timeout)
+	for {
+		line, err := e.readLine(deadline)
+		if err != nil {
+			return err
+		}
+		f := strings.SplitN(line, " ", 3)
+		if len(f) < 2 || f[1] != seq {
+			continue
+		}
+		switch f[0] {
+		case "OK":
+			return nil
+		case "ERR":
+			if len(f) == 3 {
+				return &serialNack{f[2]}
+			}
+			return &serialNack{"error"}
+		}
+	}
+}
+
+// readLine returns the next line from the port, the port returns from a read without
+// data every 100ms so that the deadline is checked
+func (e *serialEmitter) readLine(deadline time.Time) (string, error) {
+	b := make([]byte, 64)
+	for {
+		if i := bytes.IndexByte(e.buf, '\n'); i >= 0 {
+			line := strings.TrimSpace(string(e.buf[:i]))
+			e.buf = e.buf[i+1:]
+			return line, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no ack within %v", e.timeout)
+		}
+		n, err := e.port.Read(b)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		e.buf = append(e.buf, b[:n]...)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openSerial(device string, baud int) (io.ReadWriteCloser, error) {
+	return nil, errors.New("serial ports are only supported on linux")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPort is a serial port whose microcontroller answers each SEND line with reply,
+// handing out at most chunk bytes a read. A read without data waits a little and
+// returns nothing, as the real port does after VTIME.
+type testPort struct {
+	reply   func(seq string) string
+	chunk   int
+	written []string
+	pending []byte
+	closed  bool
+}
+
+func (p *testPort) Write(b []byte) (int, error) {
+	line := string(b)
+	p.written = append(p.written, line)
+	if f := strings.Fields(line); len(f) == 4 && f[0] == "SEND" && p.reply != nil {
+		p.pending = append(p.pending, p.reply(f[1])...)
+	}
+	return len(b), nil
+}
+
+func (p *testPort) Read(b []byte) (int, error) {
+	if len(p.pending) == 0 {
+		time.Sleep(5 * time.Millisecond)
+		return 0, nil
+	}
+	if p.chunk > 0 && len(b) > p.chunk {
+		b = b[:p.chunk]
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *testPort) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestSerialEmitter(t *testing.T) {
+	raw := []uint32{9000, 4500, 560}
+	ok := func(seq string) string { return "OK " + seq + "\n" }
+	tests := []struct {
+		name  string
+		reply func(seq string) string
+		chunk int
+		err   string
+		// reopen is whether the next frame opens the port again
+		reopen bool
+	}{
+		{"ok", ok, 0, "", false},
+		{"ok in pieces", func(seq string) string { return "OK " + seq + "\r\n" }, 2, "", false},
+		{"stale replies skipped", func(seq string) string {
+			return "OK 0\nready\nERR 0 late\nOK " + seq + "\n"
+		}, 0, "", false},
+		{"nack", func(seq string) string { return "ERR " + seq + " buffer full\n" }, 0, "microcontroller: buffer full", false},
+		{"no ack", nil, 0, "no ack within 50ms", true},
+		{"ack of another frame", func(seq string) string { return "OK 99\n" }, 0, "no ack", true},
+	}
+	for _, tt := range tests {
+		var ports []*testPort
+		reply := tt.reply
+		e := &serialEmitter{
+			open: func() (io.ReadWriteCloser, error) {
+				p := &testPort{reply: reply, chunk: tt.chunk}
+				ports = append(ports, p)
+				return p, nil
+			},
+			timeout: 50 * time.Millisecond,
+		}
+		err := e.Send(raw)
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+		if want := fmt.Sprintf("SEND 1 %d 9000,4500,560\n", CarrierFrequency); len(ports[0].written) != 1 || ports[0].written[0] != want {
+			t.Errorf("%s: wrote %q, want %q", tt.name, ports[0].written, want)
+		}
+		if ports[0].closed != tt.reopen {
+			t.Errorf("%s: closed %v, want %v", tt.name, ports[0].closed, tt.reopen)
+		}
+
+		// the next frame has the next sequence number, on the same port unless it failed
+		reply = ok
+		ports[0].reply = ok
+		if err := e.Send(raw); err != nil {
+			t.Errorf("%s: next frame: %v", tt.name, err)
+		}
+		want := 1
+		if tt.reopen {
+			want = 2
+		}
+		if len(ports) != want {
+			t.Errorf("%s: opened %d ports, want %d", tt.name, len(ports), want)
+		}
+		if w := ports[len(ports)-1].written; !strings.HasPrefix(w[len(w)-1], "SEND 2 ") {
+			t.Errorf("%s: next frame %q, want SEND 2", tt.name, w[len(w)-1])
+		}
+	}
+}
+
+func TestSerialEmitterOpen(t *testing.T) {
+	opens := 0
+	e := &serialEmitter{
+		open: func() (io.ReadWriteCloser, error) {
+			opens++
+			if opens == 1 {
+				return nil, errors.New("no such device")
+			}
+			return &testPort{reply: func(seq string) string { return "OK " + seq + "\n" }}, nil
+		},
+		timeout: 50 * time.Millisecond,
+	}
+	if err := e.Send([]uint32{560}); err == nil || !strings.Contains(err.Error(), "no such device") {
+		t.Errorf("got %v, want the error of open", err)
+	}
+	if err := e.Send([]uint32{560}); err != nil {
+		t.Errorf("after plugging back: %v", err)
+	}
+	if opens != 2 {
+		t.Errorf("opened %d times, want 2", opens)
+	}
+}
@@ -0,0 +1,1084 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/wtks/A75C4269"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// origins of commands
+const (
+	sourceMQTT     = "mqtt"
+	sourceHTTP     = "http"
+	sourceButton   = "button"
+	sourceSchedule = "schedule"
+	sourceReplay   = "replay"
+	sourceRestore  = "restore"
+	sourceShutdown = "shutdown"
+)
+
+// service emits states to the aircon and reports them over MQTT
+type service struct {
+	app    *gopi.AppInstance
+	client mqtt.Client
+
+	// last successfully sent state, nil if unknown
+	last   *A75C4269.Controller
+	sentAt time.Time
+	// last powered on state, restored by a command with power on only
+	lastOn *A75C4269.Controller
+	// origin of the last submitted command, for logging
+	source string
+	// when each field of DecodedState last changed
+	lastChanged map[string]time.Time
+
+	// with Coalesce, only the latest state received while waiting for MinSendGap is sent
+	pending *A75C4269.Controller
+	gap     <-chan time.Time
+	// the command being handled, or the pending state, is sent without notifying
+	silent        bool
+	pendingSilent bool
+
+	// with ReassertInterval, fires when the powered on state is to be sent again
+	reassert <-chan time.Time
+
+	// with ReactionDelays, the last state is published as transitioning until settle fires
+	transitioning bool
+	settle        <-chan time.Time
+
+	schedule  *scheduler
+	recurring *recurring
+
+	// notifiers by name and the notifications still being sent
+	notifiers map[string]notifier
+	notifying sync.WaitGroup
+
+	feedback feedback
+
+	// latest reading on AmbientTopic, nil if none
+	ambient *float64
+
+	// vacation mode, nil when not on vacation
+	vacation *vacation
+	// target fan speed of the fan ramp in progress, nil when none
+	fanTarget *byte
+	// with EnergyWatts, the energy estimate and when it is published next
+	energy     *energy
+	energyTick <-chan time.Time
+	// with InfluxURL, the writer of the points to InfluxDB
+	influx *influx
+	// with WeatherURL, the outside temperature
+	weather *weather
+
+	presence presence
+
+	// accepted commands are appended to journal if set
+	journal *journal
+
+	// emitters tried in order, delivered is the name of the last one which succeeded
+	emitters  []emitter
+	delivered string
+
+	stats stats
+	view  unitView
+	// scheduled commands as last published, for the HTTP API
+	scheduled scheduleView
+
+	// commands received over HTTP, handled like SubTopic
+	requests chan []byte
+	// schedule requests received over HTTP, handled like ScheduleTopic
+	schedules chan []byte
+	// cancel requests received over HTTP, handled like CancelTopic
+	cancels chan []byte
+	// commands received over HTTP whose outcome is waited for
+	confirmations chan *confirmation
+	// the command being handled from confirmations, nil otherwise
+	confirming *confirmation
+
+	// with PassiveSync, signals of the real remote update the state
+	passive *receiver
+}
+
+func newService(app *gopi.AppInstance, client mqtt.Client) *service {
+	s := &service{
+		app:           app,
+		client:        client,
+		schedule:      newScheduler(ScheduleFile),
+		recurring:     &recurring{path: recurringPath()},
+		schedules:     make(chan []byte),
+		cancels:       make(chan []byte),
+		requests:      make(chan []byte),
+		confirmations: make(chan *confirmation),
+		lastChanged:   make(map[string]time.Time),
+		emitters:      newEmitters(app, client),
+		notifiers:     newNotifiers(),
+		influx:        newInflux(app.Logger),
+		weather:       newWeather(app.Logger),
+	}
+	if s.weather != nil {
+		safeGo(app.Logger, s.weather.run)
+	}
+	if s.influx != nil {
+		safeGo(app.Logger, func() { s.influx.run(s.influxPeriodic) })
+	}
+	s.load()
+	s.publishVersion()
+	return s
+}
+
+// load reads the persisted state and scheduled commands
+func (s *service) load() {
+	if len(StateFile) > 0 {
+		c, err := loadState(StateFile)
+		if err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+		s.last = c
+		s.view.setState(c)
+
+		if s.lastOn, err = loadState(onStatePath()); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+		if s.lastChanged, err = loadChanged(changedPath()); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+
+	past, err := s.schedule.load()
+	if err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	for _, c := range past {
+		s.app.Logger.Warn("discarded scheduled command in the past: %s at %v", c.ID, c.At.In(Location))
+	}
+	s.publishSchedule()
+
+	if err := s.recurring.load(); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	s.planRecurring(nil)
+	s.publishRecurring()
+
+	// without StateFile vacation mode only lives in memory
+	if len(StateFile) > 0 {
+		if s.vacation, err = loadVacation(vacationPath()); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+	// the estimate lives on through a reset, only the state it follows is reloaded
+	if len(EnergyWatts) > 0 {
+		if s.energy == nil {
+			if s.energy, err = newEnergy(energyPath(), time.Now()); err != nil {
+				s.app.Logger.Error(err.Error())
+			}
+		}
+		s.updateEnergy(s.last)
+	}
+
+	if s.vacation != nil {
+		if !s.vacation.Until.After(time.Now()) {
+			s.endVacation("until passed while stopped")
+		} else {
+			s.planVacation(time.Now())
+			s.publishSchedule()
+		}
+	}
+}
+
+// reset clears the pending work of the loop and reloads the persisted state, for
+// recovering from a wedged state without dropping the connection. Nothing is sent.
+func (s *service) reset() {
+	s.app.Logger.Warn("resetting the worker")
+	if s.pending != nil {
+		s.app.Logger.Warn("reset: cleared pending state %+v", *s.pending)
+	}
+	s.pending, s.gap = nil, nil
+	if s.feedback.state != nil {
+		s.app.Logger.Warn("reset: cleared feedback wait")
+	}
+	s.feedback = feedback{}
+	if s.reassert != nil {
+		s.app.Logger.Warn("reset: cleared reassert timer")
+	}
+	s.reassert = nil
+	if s.presence.deferred != nil {
+		s.app.Logger.Warn("reset: cleared deferred state")
+	}
+	s.presence.deferred = nil
+	s.cancelInternal()
+
+	// without a schedule file the user schedules only live in memory, so they are kept
+	s.schedule.timer.Stop()
+	if len(ScheduleFile) > 0 {
+		s.schedule = newScheduler(ScheduleFile)
+	} else {
+		s.schedule.sort()
+	}
+	s.load()
+}
+
+func (s *service) publishVersion() {
+	payload, _ := json.Marshal(versionInfo())
+	if err := s.publish(VersionTopic, 1, true, string(payload)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
+
+// republish publishes the retained topics again, nothing is sent to the aircon
+func (s *service) republish() {
+	s.app.Logger.Info("republishing retained state")
+	s.publishVersion()
+	s.publishSchedule()
+	if s.last != nil {
+		s.publishState(s.last)
+	}
+	if s.energy != nil {
+		s.publishEnergy()
+	}
+	if len(s.delivered) > 0 {
+		if err := s.publish(EmitterTopic, 1, true, s.delivered); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+		payload, _ := json.Marshal(s.stats.snapshot())
+		if err := s.publish(StatsTopic, 0, true, string(payload)); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+}
+
+// serve handles messages, button presses and timers until stop is closed,
+// only LIRC failures are returned
+func (s *service) serve(stop <-chan struct{}, recv <-chan mqtt.Message, b *button, id *identifier) error {
+	var presses <-chan gopi.Event
+	if b != nil {
+		presses = b.events
+	}
+	var received <-chan gopi.Event
+	if s.passive != nil {
+		received = s.passive.events
+	}
+
+	for {
+		select {
+		case <-stop:
+			if len(ShutdownSafeState) > 0 {
+				s.emitSafeState()
+			}
+			return nil
+		case msg := <-recv:
+			if msg.Topic() == SubTopic {
+				commandReceived()
+			}
+			var err error
+			switch msg.Topic() {
+			case SubTopic:
+				if msg.Duplicate() {
+					err = s.handleRedelivery(msg.Payload())
+				} else {
+					err = s.handleAction(msg.Payload(), sourceMQTT)
+				}
+			case ScheduleTopic:
+				s.handleSchedule(msg.Payload())
+			case CancelTopic:
+				s.handleCancel(msg.Payload())
+			case AmbientTopic:
+				err = s.handleAmbient(msg.Payload())
+			case PresenceTopic:
+				err = s.handlePresence(msg.Payload())
+			case FeedbackTopic:
+				s.handleFeedback(msg.Payload())
+			case IdentifyTopic:
+				s.app.Logger.Info("identify requested")
+				id.blink()
+			case ResetTopic:
+				s.reset()
+			case RepublishTopic:
+				s.republish()
+			case DescribeTopic:
+				s.publishDescription(msg.Payload())
+			case LogLevelTopic:
+				if err := setLogLevel(s.app.Logger, string(msg.Payload())); err != nil {
+					s.fail(err)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		case payload := <-s.schedules:
+			s.handleSchedule(payload)
+		case payload := <-s.cancels:
+			s.handleCancel(payload)
+		case payload := <-s.requests:
+			commandReceived()
+			if err := s.handleAction(payload, sourceHTTP); err != nil {
+				return err
+			}
+		case c := <-s.confirmations:
+			commandReceived()
+			if err := s.confirm(c); err != nil {
+				return err
+			}
+		case evt := <-presses:
+			if b.pressed(evt) {
+				s.app.Logger.Info("button pressed")
+				if err := s.handleAction([]byte(ButtonCommand), sourceButton); err != nil {
+					return err
+				}
+			}
+		case evt := <-received:
+			if raw := s.passive.add(evt); len(raw) > 0 {
+				s.handleReceived(raw)
+			}
+		case <-s.gap:
+			if err := s.flush(); err != nil {
+				return err
+			}
+		case <-s.feedback.timer:
+			if err := s.feedbackTimeout(); err != nil {
+				return err
+			}
+		case <-s.settle:
+			s.settled()
+		case <-s.energyTick:
+			s.publishEnergy()
+		case <-s.reassert:
+			if err := s.reassertState(); err != nil {
+				return err
+			}
+		case <-s.schedule.C():
+			if err := s.fireSchedule(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleAction handles a message on SubTopic or the same command from source,
+// only LIRC failures are returned
+func (s *service) handleAction(payload []byte, source string) error {
+	if s.journal != nil {
+		if err := s.journal.write(payload); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+
+	if len(PreprocessURL) > 0 {
+		p, err := preprocess(payload)
+		if err != nil {
+			s.app.Logger.Warn(err.Error())
+			s.fail(err)
+			return nil
+		}
+		if !bytes.Equal(p, payload) {
+			s.app.Logger.Info("command from %s replaced by preprocess: %s", source, p)
+		}
+		payload = p
+	}
+
+	cmd := Command{}
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		s.fail(err)
+		return nil
+	}
+	if cmd.Notify != nil && !*cmd.Notify {
+		s.silent = true
+		defer func() { s.silent = false }()
+	}
+	if cmd.requestedTemp != nil {
+		s.event(EventTempSnapped, map[string]interface{}{"requested": *cmd.requestedTemp, "temp": cmd.PresetTemp})
+	}
+	if cmd.Action == ActionResetEnergy {
+		s.resetEnergy()
+		return nil
+	}
+	if s.vacation != nil && cmd.Action != ActionVacation {
+		s.endVacation("cancelled by a command from " + source)
+	}
+
+	if len(cmd.Raw) > 0 {
+		if err := cmd.ValidateRaw(); err != nil {
+			s.fail(err)
+			return nil
+		}
+
+		s.cancelInternal()
+		time.Sleep(s.wait())
+		return s.emitRaw(cmd.Raw)
+	}
+
+	if cmd.Boost != nil {
+		boost, settle, err := cmd.ResolveBoost()
+		if err != nil {
+			s.fail(err)
+			return nil
+		}
+
+		s.cancelInternal()
+		if err := s.submit(boost); err != nil {
+			return err
+		}
+		at := time.Now().Add(time.Duration(cmd.Boost.Minutes) * time.Minute)
+		if err := s.schedule.add(&ScheduledCommand{At: at, Controller: *settle, Tag: TagBoost}); err != nil {
+			s.fail(err)
+		}
+		s.publishSchedule()
+		return nil
+	}
+
+	if cmd.Setpoint != nil {
+		c, err := resolveSetpoint(cmd.Setpoint, s.last, s.ambient, s.weather.outside())
+		if err != nil {
+			s.fail(err)
+			return nil
+		}
+		s.app.Logger.Info("setpoint %d℃: %s", c.PresetTemp, modeName(c.Mode))
+		if !cmd.Force && s.jitter(c) {
+			return nil
+		}
+		s.cancelInternal()
+		return s.submit(c)
+	}
+
+	if cmd.Action == ActionSleepCurve {
+		return s.startSleepCurve(&cmd)
+	}
+	if cmd.Action == ActionVacation {
+		return s.startVacation(&cmd)
+	}
+
+	prev := s.rampedState()
+	c, err := cmd.Resolve(prev)
+	if err == nil {
+		c, err = s.transform(s.commandPipeline(&cmd), prev, c)
+	}
+	if err != nil {
+		s.fail(err)
+		return nil
+	}
+	if cmd.Action != ActionResend && !cmd.Force && s.jitter(c) {
+		return nil
+	}
+	s.cancelInternal()
+	if cmd.Action != ActionResend && s.duplicate(c, source) {
+		return nil
+	}
+	if steps := fanRampSteps(s.last, c); len(steps) > 0 {
+		return s.startFanRamp(c, steps)
+	}
+	return s.submit(c)
+}
+
+// handleRedelivery handles a message on SubTopic redelivered by the broker after a
+// reconnect. A state command which resolves to the current state is not emitted again
+// and the state is only published to confirm it, anything else is handled as usual.
+func (s *service) handleRedelivery(payload []byte) error {
+	cmd := Command{}
+	if err := json.Unmarshal(payload, &cmd); err == nil && s.last != nil && len(cmd.Action) == 0 &&
+		len(cmd.Raw) == 0 && cmd.Boost == nil && cmd.Setpoint == nil {
+		c, err := cmd.Resolve(s.last)
+		if err == nil {
+			c, err = s.transform(s.commandPipeline(&cmd), s.last, c)
+		}
+		if err == nil && sameState(s.last, c) {
+			s.app.Logger.Info("skipped redelivered command matching the current state")
+			s.publishState(s.last)
+			return nil
+		}
+	}
+	return s.handleAction(payload, sourceMQTT)
+}
+
+// jitter tells whether c only moves the temperature of the last state by less than
+// MinTempChange, as a noisy sensor driving an automation does
+func (s *service) jitter(c *A75C4269.Controller) bool {
+	if MinTempChange == 0 || s.last == nil || powerName(c.Power) != "on" {
+		return false
+	}
+	changed := changedFields(s.last, c)
+	if len(changed) != 1 || changed[0] != "temp" {
+		return false
+	}
+	diff := int(c.PresetTemp) - int(s.last.PresetTemp)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff >= MinTempChange {
+		return false
+	}
+	s.app.Logger.Debug("ignored temperature jitter: %d℃ to %d℃", s.last.PresetTemp, c.PresetTemp)
+	s.event(EventJitterIgnored, map[string]interface{}{"from": s.last.PresetTemp, "to": c.PresetTemp})
+	return true
+}
+
+// duplicate tells whether c was already sent within DedupWindow, as when an
+// automation and a timer fire the same command together. It records source
+// as the origin of the next emit otherwise.
+func (s *service) duplicate(c *A75C4269.Controller, source string) bool {
+	if DedupWindow > 0 && time.Since(s.sentAt) < DedupWindow && sameState(s.last, c) {
+		s.app.Logger.Info("dropped duplicate from %s, sent by %s %v ago", source, s.source, time.Since(s.sentAt).Truncate(time.Millisecond))
+		s.event(EventDuplicateDropped, map[string]interface{}{"source": source, "sent_by": s.source, "sent_at": s.sentAt})
+		return true
+	}
+	s.source = source
+	return false
+}
+
+// startSleepCurve emits the start state of a sleep curve command and schedules its steps
+func (s *service) startSleepCurve(cmd *Command) error {
+	start, steps, err := cmd.ResolveSleepCurve()
+	if err != nil {
+		s.fail(err)
+		return nil
+	}
+
+	s.cancelInternal()
+	if err := s.submit(start); err != nil {
+		return err
+	}
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixNano(), 36)
+	for i, step := range steps {
+		c := *start
+		c.PresetTemp = step.Temp
+		at := now.Add(time.Duration(step.Minutes) * time.Minute)
+		sc := &ScheduledCommand{ID: fmt.Sprintf("%s-%d/%d", id, i+1, len(steps)), At: at, Controller: c, Tag: TagSleepCurve}
+		if err := s.schedule.add(sc); err != nil {
+			s.fail(err)
+			break
+		}
+	}
+	s.app.Logger.Info("sleep curve started: %d steps", len(steps))
+	s.publishSchedule()
+	return nil
+}
+
+// cancelInternal cancels the boost, sleep curve and fan ramp steps still scheduled, a new command supersedes them
+func (s *service) cancelInternal() {
+	s.fanTarget = nil
+	cancelled, err := s.schedule.cancelInternal()
+	if err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	for _, c := range cancelled {
+		s.app.Logger.Info("cancelled scheduled command %s (%s)", c.ID, c.Tag)
+	}
+	if len(cancelled) > 0 {
+		s.publishSchedule()
+	}
+}
+
+// handleSchedule handles a message on ScheduleTopic
+func (s *service) handleSchedule(payload []byte) {
+	req := ScheduleRequest{}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		s.fail(err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		s.fail(err)
+		return
+	}
+
+	switch req.Action {
+	case "":
+		if req.Recurring != nil {
+			s.addRecurring(req.Recurring)
+			return
+		}
+		c := &ScheduledCommand{ID: req.ID, At: req.At.Time, Controller: *req.Controller}
+		if err := s.schedule.add(c); err != nil {
+			s.app.Logger.Warn(err.Error())
+			s.fail(err)
+			return
+		}
+		s.app.Logger.Info("scheduled command %s at %v", c.ID, c.At.In(Location))
+	case ScheduleActionCancel:
+		c, err := s.schedule.cancel(req.ID)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.app.Logger.Info("cancelled scheduled command %s", req.ID)
+		s.planRecurring([]*ScheduledCommand{c})
+	case ScheduleActionRemoveRecurring:
+		s.removeRecurring(req.ID)
+		return
+	case ScheduleActionList:
+		s.publishRecurring()
+	}
+	s.publishSchedule()
+}
+
+// handleCancel handles a message on CancelTopic, nothing is sent to the aircon
+func (s *service) handleCancel(payload []byte) {
+	req := CancelRequest{}
+	if len(bytes.TrimSpace(payload)) > 0 {
+		if err := json.Unmarshal(payload, &req); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+
+	cancelled, err := s.schedule.cancelMatching(&req)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	for _, c := range cancelled {
+		s.app.Logger.Info("cancelled scheduled command %s", c.ID)
+	}
+	s.publishSchedule()
+	s.planRecurring(cancelled)
+}
+
+// fireSchedule submits the scheduled commands which are due
+func (s *service) fireSchedule() error {
+	due, err := s.schedule.due()
+	if err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	s.publishSchedule()
+
+	superseded := false
+	for _, c := range due {
+		// internal steps due together with a user schedule were superseded by it
+		if superseded && len(c.Tag) > 0 {
+			s.app.Logger.Info("skipped superseded scheduled command %s (%s)", c.ID, c.Tag)
+			continue
+		}
+		if supersedesInternal(c) {
+			s.cancelInternal()
+			if s.vacation != nil {
+				s.endVacation("cancelled by scheduled command " + c.ID)
+			}
+			superseded = true
+		}
+		if vacationTag(c.Tag) {
+			if err := s.fireVacation(c); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch c.Tag {
+		case TagSleepCurve:
+			s.app.Logger.Info("sleep curve step %s: %d℃", c.ID, c.Controller.PresetTemp)
+		case TagFanRamp:
+			s.fanRampStep(c)
+		default:
+			s.app.Logger.Info("firing scheduled command %s", c.ID)
+		}
+		s.event(EventScheduleFired, map[string]interface{}{"id": c.ID, "tag": c.Tag, "rule": c.Rule, "at": c.At})
+		if c.Tag == TagPowerfulRevert {
+			s.revertPowerful()
+			continue
+		}
+		controller := c.Controller
+		if s.duplicate(&controller, sourceSchedule) {
+			continue
+		}
+		if err := s.submit(&controller); err != nil {
+			return err
+		}
+	}
+	s.planRecurring(due)
+	return nil
+}
+
+// publishSchedule publishes the pending scheduled commands on ScheduleListTopic
+func (s *service) publishSchedule() {
+	list := s.schedule.list()
+	s.scheduled.set(list)
+	payload, _ := json.Marshal(list)
+	if err := s.publish(ScheduleListTopic, 1, true, string(payload)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
+
+// submit emits c, or with Coalesce queues it as the latest pending state
+func (s *service) submit(c *A75C4269.Controller) error {
+	if Coalesce && !s.bypassesDebounce(c) {
+		if s.pending != nil {
+			s.app.Logger.Debug("superseded pending state: %+v", *s.pending)
+			s.event(EventCoalesced, map[string]interface{}{"superseded": decodeState(s.pending)})
+		}
+		s.pending = c
+		s.pendingSilent = s.silent
+		if s.gap == nil {
+			s.gap = time.After(s.wait())
+		}
+		return nil
+	}
+
+	if s.pending != nil {
+		s.app.Logger.Debug("superseded pending state: %+v", *s.pending)
+		s.pending, s.gap = nil, nil
+	}
+	time.Sleep(s.wait())
+	return s.emit(c)
+}
+
+// bypassesDebounce tells whether c changes a field of DebounceBypass from the last
+// state, which is then sent without being coalesced
+func (s *service) bypassesDebounce(c *A75C4269.Controller) bool {
+	for _, f := range changedFields(s.last, c) {
+		for _, b := range DebounceBypass {
+			if f == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flush emits the pending state once MinSendGap has passed
+func (s *service) flush() error {
+	c := s.pending
+	s.gap = nil
+	s.pending = nil
+	s.silent = s.pendingSilent
+	defer func() { s.silent = false }()
+	return s.emit(c)
+}
+
+// wait returns how long to wait before the next frame may be sent
+func (s *service) wait() time.Duration {
+	if d := MinSendGap - time.Since(s.sentAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// transmit sends raw timings repeat times through the first emitter which succeeds,
+// at least twice with DoubleSend for units which only react to a repeated frame
+func (s *service) transmit(raw []uint32, repeat int) error {
+	if DoubleSend && repeat < 2 {
+		repeat = 2
+	}
+	start := time.Now()
+	err := s.transmitChain(raw, repeat)
+	s.stats.record(start, time.Since(start), err)
+	s.view.setFailed(err != nil)
+	s.influxSend(time.Since(start), err)
+	if err == nil && s.confirming != nil {
+		s.confirming.sent = true
+	}
+
+	payload, _ := json.Marshal(s.stats.snapshot())
+	if err := s.publish(StatsTopic, 0, true, string(payload)); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	return err
+}
+
+func (s *service) transmitChain(raw []uint32, repeat int) error {
+	var err error
+	for _, e := range s.emitters {
+		if err = s.transmitVia(e, raw, repeat); err == nil {
+			s.sentAt = time.Now()
+			if e.Name() != s.delivered {
+				s.delivered = e.Name()
+				if err := s.publish(EmitterTopic, 1, true, e.Name()); err != nil {
+					s.app.Logger.Error(err.Error())
+				}
+			}
+			return nil
+		}
+		s.app.Logger.Warn("%s emitter failed: %v", e.Name(), err)
+		s.event(EventEmitterFailed, map[string]interface{}{"emitter": e.Name(), "error": err.Error()})
+	}
+	if err == nil {
+		err = errors.New("no emitter available")
+	}
+	return err
+}
+
+// transmitVia sends raw repeat times through e. The repeats left are skipped when a newer
+// command is waiting, between frames only so that a frame is never cut.
+func (s *service) transmitVia(e emitter, raw []uint32, repeat int) error {
+	for i := 0; i < repeat; i++ {
+		if i > 0 {
+			time.Sleep(DoubleSendGap)
+			if newerCommand() {
+				s.app.Logger.Info("skipped %d of %d repeats for a newer command", repeat-i, repeat)
+				s.event(EventRepeatsSkipped, map[string]interface{}{"skipped": repeat - i, "repeat": repeat})
+				return nil
+			}
+		}
+		if err := e.Send(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeatFor returns how many times c is sent: RepeatPower when it turns the aircon
+// on or off, or the state before is unknown, RepeatAdjust otherwise
+func (s *service) repeatFor(c *A75C4269.Controller) int {
+	if s.last == nil || powerName(s.last.Power) != powerName(c.Power) {
+		return RepeatPower
+	}
+	return RepeatAdjust
+}
+
+// emit sends c to the aircon, then persists, notifies and publishes it
+func (s *service) emit(c *A75C4269.Controller) error {
+	if err := Spec.Check(c); err != nil {
+		s.fail(err)
+		return nil
+	}
+
+	// powering off is always allowed
+	if powerName(c.Power) == "on" {
+		if err := checkPowerOnHours(time.Now()); err != nil {
+			s.app.Logger.Warn("rejected: %v", err)
+			s.fail(err)
+			return nil
+		}
+		if s.holdWhileAway(c) {
+			return nil
+		}
+	} else if s.presence.deferred != nil {
+		s.app.Logger.Info("presence: deferred state dropped by power off")
+		s.presence.deferred = nil
+	}
+
+	raw := c.GetRawSignal()
+	if err := verifySignal(raw); err != nil {
+		s.fail(err)
+		return nil
+	}
+
+	s.app.Logger.Debug("frame: % X", c.GetSignalBytes())
+	if LogPronto {
+		s.app.Logger.Info("pronto: %s", prontoHex(raw, CarrierFrequency))
+	}
+	if err := s.transmit(raw, s.repeatFor(c)); err != nil {
+		return err
+	}
+
+	changed := !sameState(s.last, c)
+	if len(FeedbackTopic) > 0 && powerName(c.Power) == "on" && (s.last == nil || powerName(s.last.Power) != "on") {
+		s.expectFeedback(c, false)
+	}
+	s.notify(c, changed)
+	if len(ReactionDelays) > 0 {
+		s.startTransition(c)
+	}
+	s.setState(c)
+
+	s.reassert = nil
+	if ReassertInterval > 0 && powerName(c.Power) == "on" {
+		s.reassert = time.After(ReassertInterval)
+	}
+
+	// the unit leaves powerful mode by itself after a while, counted from the last
+	// powerful frame
+	if PowerfulRevert > 0 && c.AirVolume == A75C4269.AirVolumePowerful && powerName(c.Power) == "on" {
+		if _, err := s.schedule.cancelMatching(&CancelRequest{Tag: TagPowerfulRevert}); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+		reverted := *c
+		reverted.AirVolume = A75C4269.AirVolumeAuto
+		at := time.Now().Add(PowerfulRevert)
+		if err := s.schedule.add(&ScheduledCommand{At: at, Controller: reverted, Tag: TagPowerfulRevert}); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+		s.publishSchedule()
+	}
+	return nil
+}
+
+// revertPowerful records the fan of the last state as auto when the unit has left
+// powerful mode by itself. Nothing is sent, and the other fields are those of the last
+// state, which may have changed since powerful mode was sent.
+func (s *service) revertPowerful() {
+	if s.last == nil || s.last.AirVolume != A75C4269.AirVolumePowerful || powerName(s.last.Power) != "on" {
+		s.app.Logger.Debug("powerful revert skipped, the unit is no longer in powerful mode")
+		return
+	}
+	s.app.Logger.Info("powerful mode reverted to auto by the unit")
+	c := *s.last
+	c.AirVolume = A75C4269.AirVolumeAuto
+	s.setState(&c)
+}
+
+// reassertState sends the last state again when ReassertInterval is over
+func (s *service) reassertState() error {
+	s.reassert = nil
+	if s.last == nil {
+		return nil
+	}
+	s.app.Logger.Info("reasserting the current state")
+	c := *s.last
+	return s.submit(&c)
+}
+
+// setState records c as the state of the aircon, then persists and publishes it
+func (s *service) setState(c *A75C4269.Controller) {
+	s.updateEnergy(c)
+	if fields := changedFields(s.last, c); len(fields) > 0 {
+		now := time.Now()
+		for _, f := range fields {
+			s.lastChanged[f] = now
+		}
+		if len(StateFile) > 0 {
+			if err := saveChanged(changedPath(), s.lastChanged); err != nil {
+				s.app.Logger.Error(err.Error())
+			}
+		}
+	}
+
+	s.last = c
+	s.view.setState(c)
+	s.influxState(c)
+	if len(StateFile) > 0 {
+		if err := saveState(StateFile, c); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+	if powerName(c.Power) == "on" {
+		s.lastOn = c
+		if len(StateFile) > 0 {
+			if err := saveState(onStatePath(), c); err != nil {
+				s.app.Logger.Error(err.Error())
+			}
+		}
+	}
+
+	s.publishState(c)
+}
+
+// publishState publishes c on PubTopic, along with the hash of its timings with
+// StateIncludeSignalHash, when its fields last changed with StateIncludeTimestamps and
+// whether it is transitioning with ReactionDelays, the target fan speed during a fan ramp, split with PublishSplitState and
+// formatted with StateConvention
+func (s *service) publishState(c *A75C4269.Controller) {
+	payload, _ := json.Marshal(c)
+	if StateIncludeSignalHash || StateIncludeTimestamps || len(ReactionDelays) > 0 || s.fanTarget != nil {
+		v := struct {
+			*A75C4269.Controller
+			SignalHash    string               `json:"signal_hash,omitempty"`
+			LastChanged   map[string]time.Time `json:"last_changed,omitempty"`
+			Transitioning *bool                `json:"transitioning,omitempty"`
+			FanTarget     string               `json:"fan_target,omitempty"`
+		}{Controller: c}
+		if StateIncludeSignalHash {
+			v.SignalHash = signalHash(c.GetRawSignal())
+		}
+		if StateIncludeTimestamps {
+			v.LastChanged = s.lastChanged
+		}
+		if len(ReactionDelays) > 0 {
+			v.Transitioning = &s.transitioning
+		}
+		if s.fanTarget != nil {
+			v.FanTarget = airVolumeName(*s.fanTarget)
+		}
+		payload, _ = json.Marshal(&v)
+	}
+	if err := s.publish(PubTopic, 1, true, string(payload)); err != nil {
+		s.app.Logger.Error(err.Error())
+		return
+	}
+
+	if PublishSplitState {
+		if err := s.publishSplitState(c); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+	if err := s.publishConventionState(c); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
+
+// notify sends c to the notifiers, states which did not change are handled according to NotifyUnchanged,
+// nothing is sent for a command with notify false
+func (s *service) notify(c *A75C4269.Controller, changed bool) {
+	if len(s.notifiers) == 0 {
+		return
+	}
+	if s.silent {
+		s.app.Logger.Debug("notification suppressed by the command")
+		return
+	}
+
+	text := makeMessage(c)
+	if !changed {
+		switch NotifyUnchanged {
+		case NotifyUnchangedSuppress:
+			s.app.Logger.Debug("state unchanged, notification suppressed")
+			return
+		case NotifyUnchangedMuted:
+			text = "再送(変更なし)\n" + text
+		}
+	}
+	s.dispatch(EventState, text)
+}
+
+// emitRaw sends raw timings as is, the aircon state becomes unknown afterwards
+func (s *service) emitRaw(raw []uint32) error {
+	if err := s.transmit(raw, 1); err != nil {
+		return err
+	}
+	s.app.Logger.Info("sent raw frame: %d timings", len(raw))
+
+	s.updateEnergy(nil)
+	s.last = nil
+	s.view.setState(nil)
+	if len(StateFile) > 0 {
+		if err := os.Remove(StateFile); err != nil && !os.IsNotExist(err) {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+	return nil
+}
+
+// fail logs err and reports it on ErrTopic
+func (s *service) fail(err error) {
+	if s.confirming != nil && s.confirming.rejected == nil {
+		s.confirming.rejected = err
+	}
+	s.app.Logger.Error(err.Error())
+	s.dispatch(EventError, "エラー: "+err.Error())
+	payload, _ := json.Marshal(&ErrorMessage{Error: err.Error()})
+	s.publish(ErrTopic, 0, false, string(payload))
+}
+
+// publish publishes payload on topic waiting up to MQTTTimeout for the broker, it does
+// nothing when running without the broker
+func (s *service) publish(topic string, qos byte, retained bool, payload string) error {
+	if s.client == nil {
+		return nil
+	}
+	return waitToken(s.client.Publish(topic, qos, retained, payload), "publish "+topic)
+}
+
+// publishSplitState publishes each field of c on its own retained topic under PubTopic
+func (s *service) publishSplitState(c *A75C4269.Controller) error {
+	d := decodeState(c)
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"power", d.Power},
+		{"mode", d.Mode},
+		{"temp", strconv.FormatUint(uint64(d.Temp), 10)},
+		{"fan", d.Fan},
+		{"swing", d.Swing},
+	}
+	for _, f := range fields {
+		if err := s.publish(PubTopic+"/"+f.name, 1, true, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
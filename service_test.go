@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+// TestCoalesce checks only the latest of the commands received while waiting for
+// MinSendGap is sent
+func TestCoalesce(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	coalesce, gap := Coalesce, MinSendGap
+	Coalesce, MinSendGap = true, 100*time.Millisecond
+	defer func() { Coalesce, MinSendGap = coalesce, gap }()
+
+	s := newService(testApp(t), nil)
+	s.last = &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	s.sentAt = time.Now()
+	for _, payload := range []string{
+		`{"power":"on","mode":"cool","temp":25}`,
+		`{"power":"on","mode":"cool","temp":24}`,
+		`{"power":"on","mode":"cool","temp":23}`,
+	} {
+		if err := s.handleAction([]byte(payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := emittedFrames(t); n != 0 {
+		t.Fatalf("%d frames emitted before MinSendGap", n)
+	}
+
+	select {
+	case <-s.gap:
+	case <-time.After(time.Second):
+		t.Fatal("pending state not flushed")
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want the latest only", n)
+	}
+	if s.last.PresetTemp != 23 {
+		t.Errorf("sent %d℃, want the latest 23℃", s.last.PresetTemp)
+	}
+	if s.pending != nil || s.gap != nil {
+		t.Error("pending state left after the flush")
+	}
+}
+
+// TestCoalesceBypass checks a command changing a field of DebounceBypass is sent at
+// once, superseding the pending state
+func TestCoalesceBypass(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	coalesce, gap, bypass := Coalesce, MinSendGap, DebounceBypass
+	Coalesce, MinSendGap, DebounceBypass = true, 50*time.Millisecond, []string{"power"}
+	defer func() { Coalesce, MinSendGap, DebounceBypass = coalesce, gap, bypass }()
+
+	s := newService(testApp(t), nil)
+	s.last = &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	s.sentAt = time.Now()
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":25}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleAction([]byte(`{"power":"off"}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want the power off", n)
+	}
+	if s.pending != nil {
+		t.Errorf("pending state %+v left", *s.pending)
+	}
+	if powerName(s.last.Power) != "off" {
+		t.Errorf("state %+v, want off", *s.last)
+	}
+}
+
+// TestDoubleSend checks DoubleSend sends each frame twice, DoubleSendGap apart, and
+// does not lower a higher repeat
+func TestDoubleSend(t *testing.T) {
+	double, gap, repeat := DoubleSend, DoubleSendGap, RepeatPower
+	DoubleSend, DoubleSendGap = true, 50*time.Millisecond
+	defer func() { DoubleSend, DoubleSendGap, RepeatPower = double, gap, repeat }()
+
+	tests := []struct {
+		repeat int
+		frames int
+	}{
+		{1, 2},
+		{3, 3},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		RepeatPower = tt.repeat
+		s := newService(testApp(t), nil)
+		start := time.Now()
+		if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":25}`), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		if n := emittedFrames(t); n != tt.frames {
+			t.Errorf("repeat %d: %d frames emitted, want %d", tt.repeat, n, tt.frames)
+		}
+		if want := time.Duration(tt.frames-1) * DoubleSendGap; elapsed < want {
+			t.Errorf("repeat %d: sent in %v, want at least %v between the frames", tt.repeat, elapsed, want)
+		}
+		restore()
+	}
+}
+
+// TestEmitterFallback checks a frame the first emitter fails to send goes through the
+// next one, which is then reported as the emitter delivering
+func TestEmitterFallback(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+
+	s := newService(testApp(t), nil)
+	first := &testEmitter{name: EmitterLIRC, err: errors.New("device gone")}
+	second := &testEmitter{name: EmitterMQTT}
+	s.emitters = []emitter{first, second}
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":25}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	if first.sends != 1 || second.sends != 1 {
+		t.Errorf("sent %d and %d frames, want 1 through each", first.sends, second.sends)
+	}
+	if s.delivered != EmitterMQTT {
+		t.Errorf("delivered by %q, want %s", s.delivered, EmitterMQTT)
+	}
+	if s.last == nil || s.last.PresetTemp != 25 {
+		t.Errorf("state %+v, want the sent 25℃", s.last)
+	}
+
+	second.err = errors.New("broker gone")
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":24}`), sourceMQTT); err == nil {
+		t.Error("no error with all emitters failing")
+	}
+	if s.last.PresetTemp != 25 {
+		t.Errorf("state %d℃, want 25℃ kept when nothing was sent", s.last.PresetTemp)
+	}
+}
+
+// TestViewsConcurrent reads the views of the HTTP API while the service sends and
+// schedules, for go test -race
+func TestViewsConcurrent(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+
+	s := newService(testApp(t), nil)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				json.Marshal(s.view.snapshot())
+				json.Marshal(s.stats.snapshot())
+				json.Marshal(s.scheduled.snapshot())
+			}
+		}()
+	}
+
+	at := time.Now().Add(time.Hour)
+	for i := 0; i < 20; i++ {
+		payload := fmt.Sprintf(`{"power":"on","mode":"cool","temp":%d}`, 20+i%10)
+		if err := s.handleAction([]byte(payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		sc := &ScheduledCommand{ID: fmt.Sprint(i), At: at, Controller: A75C4269.Controller{Power: A75C4269.PowerOff}}
+		if err := s.schedule.add(sc); err != nil {
+			t.Fatal(err)
+		}
+		s.publishSchedule()
+	}
+	close(done)
+	wg.Wait()
+
+	if st := s.stats.snapshot(); st.Sends != 20 {
+		t.Errorf("%d sends counted, want 20", st.Sends)
+	}
+	if n := len(s.scheduled.snapshot()); n != 20 {
+		t.Errorf("%d scheduled commands, want 20", n)
+	}
+}
+
+// TestPowerfulRevert checks the revert sets only the fan of the current state to auto,
+// when it is still powerful, and that a new powerful frame replaces the pending revert
+func TestPowerfulRevert(t *testing.T) {
+	revert := PowerfulRevert
+	PowerfulRevert = 20 * time.Millisecond
+	defer func() { PowerfulRevert = revert }()
+	powerful := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolumePowerful}
+
+	tests := []struct {
+		name string
+		// update changes the state after the powerful frame, as a passive sync would
+		update func(c *A75C4269.Controller)
+		fan    byte
+		temp   uint
+	}{
+		{"still powerful", func(c *A75C4269.Controller) { c.PresetTemp = 24 }, A75C4269.AirVolumeAuto, 24},
+		{"fan changed", func(c *A75C4269.Controller) { c.AirVolume = A75C4269.AirVolume2 }, A75C4269.AirVolume2, 26},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		s := newService(testApp(t), nil)
+		// emitted twice without a command in between, as by the steps of a fan ramp
+		for i := 0; i < 2; i++ {
+			if err := s.emit(powerful); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := len(s.scheduled.snapshot()); n != 1 {
+			t.Errorf("%s: %d reverts pending, want 1", tt.name, n)
+		}
+		c := *s.last
+		tt.update(&c)
+		s.setState(&c)
+
+		time.Sleep(2 * PowerfulRevert)
+		if err := s.fireSchedule(); err != nil {
+			t.Fatal(err)
+		}
+		if s.last.AirVolume != tt.fan || s.last.PresetTemp != tt.temp || powerName(s.last.Power) != "on" {
+			t.Errorf("%s: state %+v, want fan %d at %d℃", tt.name, *s.last, tt.fan, tt.temp)
+		}
+		if n := len(s.scheduled.snapshot()); n != 0 {
+			t.Errorf("%s: %d reverts left", tt.name, n)
+		}
+		restore()
+	}
+}
+
+// TestReassert checks the powered on state is sent again every ReassertInterval, and
+// not once powered off
+func TestReassert(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	interval := ReassertInterval
+	ReassertInterval = 20 * time.Millisecond
+	defer func() { ReassertInterval = interval }()
+
+	s := newService(testApp(t), nil)
+	if err := s.handleAction([]byte(`{"power":"on","mode":"heat","temp":22}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-s.reassert:
+		case <-time.After(time.Second):
+			t.Fatalf("not reasserted after %d frames", i+1)
+		}
+		if err := s.reassertState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := emittedFrames(t); n != 3 {
+		t.Errorf("%d frames emitted, want 1 and 2 reasserted", n)
+	}
+	if s.last.PresetTemp != 22 || s.last.Mode != A75C4269.ModeHeater {
+		t.Errorf("state %+v changed by reasserting", *s.last)
+	}
+
+	if err := s.handleAction([]byte(`{"power":"off"}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	if s.reassert != nil {
+		t.Error("reassert timer left after powering off")
+	}
+}
+
+// TestBarePowerOn checks a command with power on only turns the unit back on as it was
+// last powered on, while any other field sends the command as given
+func TestBarePowerOn(t *testing.T) {
+	lastOn := A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 24, AirVolume: A75C4269.AirVolume2, WindDirection: A75C4269.WindDirection3}
+	tests := []struct {
+		name    string
+		payload string
+		restore bool
+		mode    byte
+	}{
+		{"bare", `{"power":"on"}`, true, A75C4269.ModeCooler},
+		{"bare bool", `{"power":true}`, true, A75C4269.ModeCooler},
+		{"bare with notify", `{"power":"on","notify":false}`, true, A75C4269.ModeCooler},
+		{"explicit", `{"power":"on","mode":"heat","temp":22}`, false, A75C4269.ModeHeater},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		s := newService(testApp(t), nil)
+		on := lastOn
+		if err := s.emit(&on); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.handleAction([]byte(`{"power":"off"}`), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.handleAction([]byte(tt.payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if got := *s.last; (got == lastOn) != tt.restore || got.Mode != tt.mode || powerName(got.Power) != "on" {
+			t.Errorf("%s: state %+v, restored %v, want %v", tt.name, got, got == lastOn, tt.restore)
+		}
+		if n := emittedFrames(t); n != 3 {
+			t.Errorf("%s: %d frames emitted, want 3", tt.name, n)
+		}
+		restore()
+	}
+}
+
+// TestRepeatPerClass checks power changes and unknown states are sent RepeatPower
+// times, other changes RepeatAdjust times
+func TestRepeatPerClass(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	power, adjust, gap := RepeatPower, RepeatAdjust, DoubleSendGap
+	RepeatPower, RepeatAdjust, DoubleSendGap = 3, 2, time.Millisecond
+	defer func() { RepeatPower, RepeatAdjust, DoubleSendGap = power, adjust, gap }()
+
+	s := newService(testApp(t), nil)
+	e := &testEmitter{name: EmitterFile}
+	s.emitters = []emitter{e}
+	tests := []struct {
+		name    string
+		payload string
+		sends   int
+	}{
+		{"unknown state", `{"power":"on","mode":"cool","temp":26}`, 3},
+		{"temperature", `{"power":"on","mode":"cool","temp":25}`, 2},
+		{"fan", `{"power":"on","mode":"cool","temp":25,"fan":"3"}`, 2},
+		{"power off", `{"power":"off","mode":"cool","temp":25}`, 3},
+		{"power on", `{"power":"on","mode":"heat","temp":22}`, 3},
+	}
+	for _, tt := range tests {
+		e.sends = 0
+		if err := s.handleAction([]byte(tt.payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if e.sends != tt.sends {
+			t.Errorf("%s: sent %d times, want %d", tt.name, e.sends, tt.sends)
+		}
+	}
+}
+
+// TestDebounceBypass checks only a change of a field of DebounceBypass skips the
+// coalescing, whatever the other fields
+func TestDebounceBypass(t *testing.T) {
+	coalesce, gap, bypass := Coalesce, MinSendGap, DebounceBypass
+	Coalesce, MinSendGap = true, 50*time.Millisecond
+	defer func() { Coalesce, MinSendGap, DebounceBypass = coalesce, gap, bypass }()
+
+	tests := []struct {
+		name    string
+		bypass  []string
+		payload string
+		sent    bool
+	}{
+		{"no bypass", nil, `{"power":"off"}`, false},
+		{"power", []string{"power"}, `{"power":"off"}`, true},
+		{"other field", []string{"power"}, `{"power":"on","mode":"cool","temp":24}`, false},
+		{"mode", []string{"mode", "swing"}, `{"power":"on","mode":"heat","temp":26}`, true},
+		{"swing", []string{"mode", "swing"}, `{"power":"on","mode":"cool","temp":26,"swing":"down"}`, true},
+		{"same value", []string{"mode"}, `{"power":"on","mode":"cool","temp":25}`, false},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		DebounceBypass = tt.bypass
+		s := newService(testApp(t), nil)
+		s.last = &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+		s.sentAt = time.Now()
+		if err := s.handleAction([]byte(tt.payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if n := emittedFrames(t); (n == 1) != tt.sent {
+			t.Errorf("%s: %d frames emitted, sent at once %v", tt.name, n, tt.sent)
+		}
+		if (s.pending == nil) != tt.sent {
+			t.Errorf("%s: pending %+v, sent at once %v", tt.name, s.pending, tt.sent)
+		}
+		restore()
+	}
+}
+
+// TestRedelivery checks a command redelivered by the broker is not sent again when it
+// matches the current state
+func TestRedelivery(t *testing.T) {
+	tests := []struct {
+		name    string
+		last    *A75C4269.Controller
+		payload string
+		frames  int
+	}{
+		{"same state", &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}, `{"power":"on","mode":"cool","temp":26}`, 0},
+		{"bare power on", &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}, `{"power":"on"}`, 0},
+		{"other state", &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}, `{"power":"on","mode":"cool","temp":25}`, 1},
+		{"unknown state", nil, `{"power":"on","mode":"cool","temp":26}`, 1},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		s := newService(testApp(t), nil)
+		if tt.last != nil {
+			s.setState(tt.last)
+		}
+		if err := s.handleRedelivery([]byte(tt.payload)); err != nil {
+			t.Fatal(err)
+		}
+		if n := emittedFrames(t); n != tt.frames {
+			t.Errorf("%s: %d frames emitted, want %d", tt.name, n, tt.frames)
+		}
+		restore()
+	}
+}
+
+// TestMinTempChange checks a temperature change smaller than MinTempChange is ignored
+// unless forced, resent or combined with another change
+func TestMinTempChange(t *testing.T) {
+	change := MinTempChange
+	MinTempChange = 2
+	defer func() { MinTempChange = change }()
+
+	tests := []struct {
+		name    string
+		payload string
+		frames  int
+	}{
+		{"small change", `{"power":"on","mode":"cool","temp":25}`, 0},
+		{"large change", `{"power":"on","mode":"cool","temp":24}`, 1},
+		{"small change up", `{"power":"on","mode":"cool","temp":27}`, 0},
+		{"forced", `{"power":"on","mode":"cool","temp":25,"force":true}`, 1},
+		{"with the fan", `{"power":"on","mode":"cool","temp":25,"fan":"2"}`, 1},
+		{"with the mode", `{"power":"on","mode":"dry","temp":25}`, 1},
+		{"power off", `{"power":"off","mode":"cool","temp":25}`, 1},
+		{"resend", `{"action":"resend"}`, 1},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		s := newService(testApp(t), nil)
+		s.setState(&A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26})
+		if err := s.handleAction([]byte(tt.payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		if n := emittedFrames(t); n != tt.frames {
+			t.Errorf("%s: %d frames emitted, want %d", tt.name, n, tt.frames)
+		}
+		restore()
+	}
+}
+
+// TestRepeatsPreempted checks the repeats left of a frame are skipped once a newer
+// command waits for the loop, while the frame being sent is not cut
+func TestRepeatsPreempted(t *testing.T) {
+	power, gap := RepeatPower, DoubleSendGap
+	RepeatPower, DoubleSendGap = 4, 10*time.Millisecond
+	defer func() { RepeatPower, DoubleSendGap = power, gap }()
+
+	tests := []struct {
+		name string
+		// waitAfter is the frame after which a command starts waiting, 0 for none
+		waitAfter int
+		sends     int
+	}{
+		{"no newer command", 0, 4},
+		{"during the first frame", 1, 1},
+		{"during the second frame", 2, 2},
+	}
+	for _, tt := range tests {
+		_, restore := testConfig(t)
+		s := newService(testApp(t), nil)
+		e := &testEmitter{name: EmitterFile}
+		e.onSend = func() {
+			if e.sends == tt.waitAfter {
+				commandWaiting()
+			}
+		}
+		s.emitters = []emitter{e}
+		err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26}`), sourceMQTT)
+		if tt.waitAfter > 0 {
+			commandReceived()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.sends != tt.sends {
+			t.Errorf("%s: sent %d times, want %d", tt.name, e.sends, tt.sends)
+		}
+		if s.last == nil || s.last.PresetTemp != 26 {
+			t.Errorf("%s: state %+v, want the preempted frame recorded as sent", tt.name, s.last)
+		}
+		restore()
+	}
+}
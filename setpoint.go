@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"strconv"
+	"strings"
+)
+
+// Setpoint asks for a target temperature only, the mode is chosen by the service
+type Setpoint struct {
+	Temp uint `json:"temp"`
+	// Mode is used when the ambient temperature does not decide it
+	Mode string `json:"mode,omitempty"`
+}
+
+// handleAmbient handles a temperature reading on AmbientTopic, which may start or stop
+// anti-freeze heating in vacation mode. Only LIRC failures are returned.
+func (s *service) handleAmbient(payload []byte) error {
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		s.app.Logger.Warn("invalid ambient temperature: %v", err)
+		return nil
+	}
+	s.ambient = &value
+	return s.guardFreeze(value)
+}
+
+// resolveSetpoint returns the powered on state reaching sp.
+//
+// With an ambient temperature more than SetpointThreshold above the target it cools,
+// more than SetpointThreshold below it heats. Switching away from the mode of last
+// needs SetpointHysteresis more. With an outside temperature, it does not heat at or
+// above OutsideNoHeatAbove nor cool at or below OutsideNoCoolBelow. Otherwise, or
+// without an ambient temperature, the mode hint is used, then the mode of last.
+func resolveSetpoint(sp *Setpoint, last *A75C4269.Controller, ambient, outside *float64) (*A75C4269.Controller, error) {
+	c := A75C4269.Controller{}
+	if last != nil {
+		c = *last
+	}
+	c.Power = A75C4269.PowerOn
+	c.PresetTemp = sp.Temp
+
+	decided := false
+	if ambient != nil {
+		coolAt, heatAt := SetpointThreshold, SetpointThreshold
+		if last != nil && powerName(last.Power) == "on" {
+			switch last.Mode {
+			case A75C4269.ModeCooler:
+				heatAt += SetpointHysteresis
+			case A75C4269.ModeHeater:
+				coolAt += SetpointHysteresis
+			}
+		}
+		diff := *ambient - float64(sp.Temp)
+		switch {
+		case diff > coolAt && (outside == nil || *outside > OutsideNoCoolBelow):
+			c.Mode, decided = A75C4269.ModeCooler, true
+		case -diff > heatAt && (outside == nil || *outside < OutsideNoHeatAbove):
+			c.Mode, decided = A75C4269.ModeHeater, true
+		}
+	}
+
+	if !decided && len(sp.Mode) > 0 {
+		mode, ok := modeValues[strings.ToLower(sp.Mode)]
+		if !ok {
+			return nil, fmt.Errorf("invalid mode: %s", sp.Mode)
+		}
+		c.Mode, decided = mode, true
+	}
+	if !decided && last == nil {
+		return nil, errors.New("setpoint needs a mode when neither the ambient temperature nor the previous state decides it")
+	}
+	if err := Spec.Check(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestResolveSetpoint(t *testing.T) {
+	temp := func(v float64) *float64 { return &v }
+	cooling := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume2}
+	heating := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 20}
+	off := &A75C4269.Controller{Power: A75C4269.PowerOff, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+
+	// the defaults: SetpointThreshold 1, SetpointHysteresis 0.5, no heating from 20℃
+	// outside, no cooling up to 15℃ outside
+	tests := []struct {
+		name             string
+		sp               Setpoint
+		last             *A75C4269.Controller
+		ambient, outside *float64
+		mode             byte
+		err              string
+	}{
+		{"hot room cools", Setpoint{Temp: 24}, nil, temp(27), nil, A75C4269.ModeCooler, ""},
+		{"cold room heats", Setpoint{Temp: 22}, nil, temp(18), nil, A75C4269.ModeHeater, ""},
+		{"within threshold keeps the previous mode", Setpoint{Temp: 24}, heating, temp(24.5), nil, A75C4269.ModeHeater, ""},
+		{"within threshold uses the hint", Setpoint{Temp: 24, Mode: "dry"}, nil, temp(24.5), nil, A75C4269.ModeDehumidifier, ""},
+		{"switching from cooling needs the hysteresis", Setpoint{Temp: 24}, cooling, temp(22.8), nil, A75C4269.ModeCooler, ""},
+		{"switching from cooling past the hysteresis", Setpoint{Temp: 24}, cooling, temp(22.4), nil, A75C4269.ModeHeater, ""},
+		{"switching from heating needs the hysteresis", Setpoint{Temp: 22}, heating, temp(23.2), nil, A75C4269.ModeHeater, ""},
+		{"no hysteresis when off", Setpoint{Temp: 24}, off, temp(22.8), nil, A75C4269.ModeHeater, ""},
+		{"warm outside does not heat", Setpoint{Temp: 22, Mode: "cool"}, nil, temp(18), temp(21), A75C4269.ModeCooler, ""},
+		{"cold outside does not cool", Setpoint{Temp: 24, Mode: "heat"}, nil, temp(27), temp(10), A75C4269.ModeHeater, ""},
+		{"mild outside cools", Setpoint{Temp: 24}, nil, temp(27), temp(18), A75C4269.ModeCooler, ""},
+		{"no ambient uses the hint", Setpoint{Temp: 22, Mode: "Heat"}, cooling, nil, nil, A75C4269.ModeHeater, ""},
+		{"no ambient keeps the previous mode", Setpoint{Temp: 25}, cooling, nil, nil, A75C4269.ModeCooler, ""},
+		{"nothing decides", Setpoint{Temp: 24}, nil, nil, nil, 0, "setpoint needs a mode"},
+		{"invalid hint", Setpoint{Temp: 24, Mode: "fan"}, nil, nil, nil, 0, "invalid mode: fan"},
+		{"out of range", Setpoint{Temp: 40}, nil, temp(20), nil, 0, "temp"},
+	}
+	for _, tt := range tests {
+		c, err := resolveSetpoint(&tt.sp, tt.last, tt.ambient, tt.outside)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if c.Mode != tt.mode || c.Power != A75C4269.PowerOn || c.PresetTemp != tt.sp.Temp {
+			t.Errorf("%s: got %+v, want on, mode %d, %d℃", tt.name, c, tt.mode, tt.sp.Temp)
+		}
+		if tt.last != nil && c.AirVolume != tt.last.AirVolume {
+			t.Errorf("%s: fan %d, want %d of the previous state", tt.name, c.AirVolume, tt.last.AirVolume)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/wtks/A75C4269"
+	"os"
+	"strings"
+	"time"
+)
+
+// SafeState is the state emitted on shutdown with ShutdownSafeState, nil for "off"
+var SafeState *A75C4269.Controller
+
+// parseSafeState parses SHUTDOWN_SAFE_STATE, a plain state or "off", which returns nil
+// as the last state is powered off then
+func parseSafeState(v string) (*A75C4269.Controller, error) {
+	if strings.ToLower(strings.TrimSpace(v)) == "off" {
+		return nil, nil
+	}
+	cmd := Command{}
+	if err := json.Unmarshal([]byte(v), &cmd); err != nil {
+		return nil, err
+	}
+	if len(cmd.Action) > 0 || len(cmd.Raw) > 0 || cmd.Boost != nil || len(cmd.Preset) > 0 || cmd.Setpoint != nil {
+		return nil, errors.New("only a plain state or off can be the safe state")
+	}
+	if err := Spec.Check(&cmd.Controller); err != nil {
+		return nil, err
+	}
+	return &cmd.Controller, nil
+}
+
+// emitSafeState emits ShutdownSafeState when stopped by a signal. Failures are logged
+// only, the service stops anyway, and so it does when the wait for MinSendGap, the
+// emit and its notifications take longer than ShutdownTimeout. The returned channel is
+// closed once they are over, which may be after emitSafeState returned.
+func (s *service) emitSafeState() <-chan struct{} {
+	done := make(chan struct{})
+	if usesEmitter(EmitterLIRC) {
+		if _, err := os.Stat(LIRCDevice); err != nil {
+			s.app.Logger.Warn("shutdown: safe state skipped, LIRC device is gone: %v", err)
+			close(done)
+			return done
+		}
+	}
+
+	c := SafeState
+	if c == nil {
+		off := A75C4269.Controller{Power: A75C4269.PowerOff}
+		if s.last != nil {
+			off = *s.last
+			off.Power = A75C4269.PowerOff
+		}
+		c = &off
+	}
+	if sameState(s.last, c) {
+		s.app.Logger.Info("shutdown: already in the safe state")
+		close(done)
+		return done
+	}
+
+	s.app.Logger.Info("shutdown: emitting the safe state: %s", strings.Replace(makeMessage(c), "\n", ", ", -1))
+	s.source = sourceShutdown
+	safeGo(s.app.Logger, func() {
+		defer close(done)
+		time.Sleep(s.wait())
+		if err := s.emit(c); err != nil {
+			s.app.Logger.Error("shutdown: safe state failed: %v", err)
+		}
+		s.notifying.Wait()
+	})
+	select {
+	case <-done:
+	case <-time.After(ShutdownTimeout):
+		s.app.Logger.Error("shutdown: safe state not done within %v, stopping anyway", ShutdownTimeout)
+	}
+	return done
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+// blockNotifier blocks every notification until release is closed
+type blockNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockNotifier) Name() string { return "block" }
+
+func (n *blockNotifier) Notify(string) error {
+	<-n.release
+	return nil
+}
+
+// TestSafeStateTimeout checks stopping does not wait past ShutdownTimeout for a
+// notifier which hangs, the safe state being sent all the same
+func TestSafeStateTimeout(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	safe, state, timeout := ShutdownSafeState, SafeState, ShutdownTimeout
+	ShutdownSafeState, SafeState, ShutdownTimeout = "off", nil, 50*time.Millisecond
+	defer func() { ShutdownSafeState, SafeState, ShutdownTimeout = safe, state, timeout }()
+
+	s := newService(testApp(t), nil)
+	s.setState(&A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26})
+	n := &blockNotifier{release: make(chan struct{})}
+	s.notifiers = map[string]notifier{"block": n}
+
+	start := time.Now()
+	done := s.emitSafeState()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("stopped after %v, want about %v", d, ShutdownTimeout)
+	}
+	select {
+	case <-done:
+		t.Error("done with the notification blocked")
+	default:
+	}
+
+	close(n.release)
+	<-done
+	if n := emittedFrames(t); n != 1 {
+		t.Errorf("%d frames emitted, want the safe state", n)
+	}
+	if s.last == nil || powerName(s.last.Power) != "off" {
+		t.Errorf("state %+v, want off", s.last)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"math"
+	"sort"
+	"strings"
+)
+
+// TempRange is the range of PresetTemp accepted in a mode
+type TempRange struct {
+	Min uint `json:"min"`
+	Max uint `json:"max"`
+}
+
+// ModelSpec is the single source of the values a remote model accepts, used both to
+// validate states and to report capabilities
+type ModelSpec struct {
+	Model          string
+	Powers         map[string]byte
+	Modes          map[string]byte
+	AirVolumes     map[string]byte
+	WindDirections map[string]byte
+	// TempRanges are keyed by mode
+	TempRanges map[byte]TempRange
+	// TempStep is the granularity of PresetTemp, counted from the minimum of the range
+	TempStep     uint
+	MaxTimerHour byte
+}
+
+// Spec is the spec of the emulated remote
+var Spec = &ModelSpec{
+	Model:          RemoteModel,
+	Powers:         powerValues,
+	Modes:          modeValues,
+	AirVolumes:     airVolumeValues,
+	WindDirections: windDirectionValues,
+	TempRanges: map[byte]TempRange{
+		A75C4269.ModeCooler:       {MinTemp, MaxTemp},
+		A75C4269.ModeHeater:       {MinTemp, MaxTemp},
+		A75C4269.ModeDehumidifier: {MinTemp, MaxTemp},
+	},
+	TempStep:     1,
+	MaxTimerHour: 12,
+}
+
+// SetTempRange narrows the temperature of every mode to min-max in steps of step. The
+// maximum is lowered to the last step within the range.
+func (m *ModelSpec) SetTempRange(min, max, step uint) error {
+	if min < MinTemp || max > MaxTemp || min > max {
+		return fmt.Errorf("invalid range %d-%d (%d-%d)", min, max, MinTemp, MaxTemp)
+	}
+	if step < 1 || step > MaxTemp-MinTemp {
+		return fmt.Errorf("invalid step %d (1-%d)", step, MaxTemp-MinTemp)
+	}
+	max = min + (max-min)/step*step
+	for mode := range m.TempRanges {
+		m.TempRanges[mode] = TempRange{min, max}
+	}
+	m.TempStep = step
+	return nil
+}
+
+// SnapTemp rounds t to the nearest step of the range of mode. Values out of the range
+// are left for Validate to reject.
+func (m *ModelSpec) SnapTemp(mode byte, t float64) uint {
+	r, ok := m.TempRanges[mode]
+	if !ok || m.TempStep == 0 || t < float64(r.Min) {
+		return uint(math.Round(math.Max(t, 0)))
+	}
+	n := math.Round((t - float64(r.Min)) / float64(m.TempStep))
+	return r.Min + uint(n)*m.TempStep
+}
+
+// Validate returns the rules c violates. The temperature is not checked for power off,
+// where the unit ignores it.
+func (m *ModelSpec) Validate(c *A75C4269.Controller) []string {
+	var violations []string
+	for _, f := range []struct {
+		name   string
+		value  byte
+		values map[string]byte
+	}{
+		{"power", c.Power, m.Powers},
+		{"mode", c.Mode, m.Modes},
+		{"fan", c.AirVolume, m.AirVolumes},
+		{"swing", c.WindDirection, m.WindDirections},
+	} {
+		if !hasValue(f.values, f.value) {
+			violations = append(violations, fmt.Sprintf("invalid %s: %d", f.name, f.value))
+		}
+	}
+
+	if r, ok := m.TempRanges[c.Mode]; ok && c.Power != A75C4269.PowerOff {
+		if c.PresetTemp < r.Min || c.PresetTemp > r.Max {
+			violations = append(violations, fmt.Sprintf("temperature out of range for %s: %d (%d-%d)", modeName(c.Mode), c.PresetTemp, r.Min, r.Max))
+		} else if m.TempStep > 1 && (c.PresetTemp-r.Min)%m.TempStep != 0 {
+			violations = append(violations, fmt.Sprintf("temperature not in steps of %d from %d: %d", m.TempStep, r.Min, c.PresetTemp))
+		}
+	}
+
+	switch c.Power {
+	case A75C4269.PowerOnAndOffTimer, A75C4269.PowerOffAndOnTimer:
+		if c.TimerHour < 1 || c.TimerHour > m.MaxTimerHour {
+			violations = append(violations, fmt.Sprintf("timer hour out of range: %d (1-%d)", c.TimerHour, m.MaxTimerHour))
+		}
+	}
+	return violations
+}
+
+// Check returns the violations of c as an error, nil if there is none
+func (m *ModelSpec) Check(c *A75C4269.Controller) error {
+	if v := m.Validate(c); len(v) > 0 {
+		return errors.New(strings.Join(v, "; "))
+	}
+	return nil
+}
+
+// Capabilities is the spec in the form returned by CapabilitiesPath
+type Capabilities struct {
+	Model        string               `json:"model"`
+	Power        []string             `json:"power"`
+	Mode         map[string]TempRange `json:"mode"`
+	Fan          []string             `json:"fan"`
+	Swing        []string             `json:"swing"`
+	TempStep     uint                 `json:"temp_step"`
+	MaxTimerHour byte                 `json:"max_timer_hour"`
+}
+
+func (m *ModelSpec) Capabilities() *Capabilities {
+	modes := map[string]TempRange{}
+	for name, mode := range m.Modes {
+		modes[name] = m.TempRanges[mode]
+	}
+	return &Capabilities{
+		Model:        m.Model,
+		Power:        sortedNames(m.Powers),
+		Mode:         modes,
+		Fan:          sortedNames(m.AirVolumes),
+		Swing:        sortedNames(m.WindDirections),
+		TempStep:     m.TempStep,
+		MaxTimerHour: m.MaxTimerHour,
+	}
+}
+
+func hasValue(values map[string]byte, value byte) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNames(values map[string]byte) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestSpecCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		c    A75C4269.Controller
+		err  string
+	}{
+		{"valid", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}, ""},
+		{"lowest", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: MinTemp}, ""},
+		{"highest", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeDehumidifier, PresetTemp: MaxTemp}, ""},
+		{"too cold", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 15}, "temperature out of range for cool: 15 (16-30)"},
+		{"too hot", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 31}, "temperature out of range for heat"},
+		{"power off ignores the temperature", A75C4269.Controller{Power: A75C4269.PowerOff, Mode: A75C4269.ModeCooler}, ""},
+		{"invalid power", A75C4269.Controller{Power: 9, Mode: A75C4269.ModeCooler, PresetTemp: 26}, "invalid power: 9"},
+		{"invalid mode", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: 3, PresetTemp: 26}, "invalid mode: 3"},
+		{"invalid fan", A75C4269.Controller{Power: A75C4269.PowerOn, PresetTemp: 26, AirVolume: 7}, "invalid fan: 7"},
+		{"invalid swing", A75C4269.Controller{Power: A75C4269.PowerOn, PresetTemp: 26, WindDirection: 6}, "invalid swing: 6"},
+		{"timer", A75C4269.Controller{Power: A75C4269.PowerOnAndOffTimer, PresetTemp: 26, TimerHour: 12}, ""},
+		{"no timer hour", A75C4269.Controller{Power: A75C4269.PowerOffAndOnTimer, PresetTemp: 26}, "timer hour out of range: 0 (1-12)"},
+		{"timer too long", A75C4269.Controller{Power: A75C4269.PowerOnAndOffTimer, PresetTemp: 26, TimerHour: 13}, "timer hour out of range: 13"},
+		{"every violation", A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 40, AirVolume: 9}, "invalid fan: 9; temperature out of range"},
+	}
+	for _, tt := range tests {
+		err := Spec.Check(&tt.c)
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSnapTemp(t *testing.T) {
+	spec := func(min, max, step uint) *ModelSpec {
+		m := &ModelSpec{TempRanges: map[byte]TempRange{
+			A75C4269.ModeCooler: {MinTemp, MaxTemp},
+			A75C4269.ModeHeater: {MinTemp, MaxTemp},
+		}}
+		if err := m.SetTempRange(min, max, step); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	tests := []struct {
+		name string
+		spec *ModelSpec
+		mode byte
+		t    float64
+		want uint
+	}{
+		{"whole degree", spec(16, 30, 1), A75C4269.ModeCooler, 26, 26},
+		{"rounded down", spec(16, 30, 1), A75C4269.ModeCooler, 22.4, 22},
+		{"rounded up", spec(16, 30, 1), A75C4269.ModeCooler, 22.5, 23},
+		{"steps from the minimum", spec(17, 29, 2), A75C4269.ModeHeater, 20, 21},
+		{"nearest step", spec(17, 29, 2), A75C4269.ModeHeater, 19.9, 19},
+		{"maximum lowered to a step", spec(16, 30, 3), A75C4269.ModeCooler, 28, 28},
+		{"below the range left to Validate", spec(18, 28, 2), A75C4269.ModeCooler, 16.6, 17},
+		{"above the range left to Validate", spec(18, 28, 2), A75C4269.ModeCooler, 31, 32},
+		{"mode without a range", spec(16, 30, 2), A75C4269.ModeDehumidifier, 21.6, 22},
+		{"negative", spec(16, 30, 1), A75C4269.ModeDehumidifier, -3, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.spec.SnapTemp(tt.mode, tt.t); got != tt.want {
+			t.Errorf("%s: SnapTemp(%d, %v) = %d, want %d", tt.name, tt.mode, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestSetTempRange(t *testing.T) {
+	m := &ModelSpec{TempRanges: map[byte]TempRange{A75C4269.ModeCooler: {MinTemp, MaxTemp}}}
+	if err := m.SetTempRange(16, 30, 3); err != nil {
+		t.Fatal(err)
+	}
+	if r := m.TempRanges[A75C4269.ModeCooler]; r != (TempRange{16, 28}) || m.TempStep != 3 {
+		t.Errorf("range %+v in steps of %d, want 16-28 in steps of 3", r, m.TempStep)
+	}
+	for _, tt := range []struct{ min, max, step uint }{
+		{15, 30, 1}, {16, 31, 1}, {25, 20, 1}, {16, 30, 0}, {16, 30, 15},
+	} {
+		if err := m.SetTempRange(tt.min, tt.max, tt.step); err == nil {
+			t.Errorf("SetTempRange(%d, %d, %d) accepted", tt.min, tt.max, tt.step)
+		}
+	}
+}
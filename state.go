@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// onStatePath is where the last powered on state is kept next to StateFile
+func onStatePath() string {
+	return StateFile + ".on"
+}
+
+// changedPath is where the times the fields last changed are kept next to StateFile
+func changedPath() string {
+	return StateFile + ".changed"
+}
+
+// signalHash returns the CRC32 of raw as little endian uint32, in hex, so that clients
+// can tell whether two versions produce the same timings for a state
+func signalHash(raw []uint32) string {
+	b := make([]byte, 4*len(raw))
+	for i, t := range raw {
+		binary.LittleEndian.PutUint32(b[4*i:], t)
+	}
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(b))
+}
+
+// sameState reports whether a and b put the aircon into the same state
+func sameState(a, b *A75C4269.Controller) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a.Power == A75C4269.PowerOff && b.Power == A75C4269.PowerOff {
+		return true
+	}
+	return bytes.Equal(a.GetSignalBytes(), b.GetSignalBytes())
+}
+
+// loadState reads the last sent state from path, returns nil if it does not exist yet
+func loadState(path string) (*A75C4269.Controller, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	c := &A75C4269.Controller{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// saveState writes c to path, replacing the file atomically
+func saveState(path string, c *A75C4269.Controller) error {
+	b, _ := json.Marshal(c)
+	return writeFileAtomic(path, b)
+}
+
+// loadChanged reads the times the fields of the state last changed from path, returns
+// an empty map if it does not exist yet
+func loadChanged(path string) (map[string]time.Time, error) {
+	changed := make(map[string]time.Time)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return changed, nil
+	} else if err != nil {
+		return changed, err
+	}
+	if err := json.Unmarshal(b, &changed); err != nil {
+		return make(map[string]time.Time), err
+	}
+	return changed, nil
+}
+
+// saveChanged writes the times the fields of the state last changed to path
+func saveChanged(path string, changed map[string]time.Time) error {
+	b, _ := json.Marshal(changed)
+	return writeFileAtomic(path, b)
+}
+
+// writeFileAtomic writes b to path through a temporary file in the same directory
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// changedFields returns the names of the fields of DecodedState which differ between a
+// and b, all of them when a is unknown
+func changedFields(a, b *A75C4269.Controller) []string {
+	fields := []string{"power", "mode", "temp", "fan", "swing"}
+	if a == nil {
+		return fields
+	}
+	da, db := decodeState(a), decodeState(b)
+	changed := []bool{
+		da.Power != db.Power,
+		da.Mode != db.Mode,
+		da.Temp != db.Temp,
+		da.Fan != db.Fan,
+		da.Swing != db.Swing,
+	}
+	var names []string
+	for i, c := range changed {
+		if c {
+			names = append(names, fields[i])
+		}
+	}
+	return names
+}
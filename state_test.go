@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestSignalHash(t *testing.T) {
+	// CRC32 of 28 23 00 00 94 11 00 00 30 02 00 00
+	if got := signalHash([]uint32{9000, 4500, 560}); got != "e35a8301" {
+		t.Errorf("got %s, want e35a8301", got)
+	}
+	cool := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	heat := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 26}
+	if signalHash(cool.GetRawSignal()) == signalHash(heat.GetRawSignal()) {
+		t.Error("same hash for different states")
+	}
+}
+
+// TestPublishSignalHash checks the state is published with the hash of the timings sent
+// with StateIncludeSignalHash only
+func TestPublishSignalHash(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+	include := StateIncludeSignalHash
+	defer func() { StateIncludeSignalHash = include }()
+
+	s, states, close := testPublishing(t, broker, PubTopic)
+	defer close()
+	want := A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	for _, tt := range []struct {
+		include bool
+		payload string
+	}{
+		{true, `{"power":"on","mode":"cool","temp":26}`},
+		{false, `{"action":"resend"}`},
+	} {
+		include := tt.include
+		StateIncludeSignalHash = include
+		if err := s.handleAction([]byte(tt.payload), sourceMQTT); err != nil {
+			t.Fatal(err)
+		}
+		v := struct {
+			A75C4269.Controller
+			SignalHash *string `json:"signal_hash"`
+		}{}
+		if err := json.Unmarshal(receive(t, states, "state").Payload(), &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Controller != want {
+			t.Errorf("state %+v, want %+v", v.Controller, want)
+		}
+		switch {
+		case include && (v.SignalHash == nil || *v.SignalHash != signalHash(want.GetRawSignal())):
+			t.Errorf("hash %v, want %s", v.SignalHash, signalHash(want.GetRawSignal()))
+		case !include && v.SignalHash != nil:
+			t.Errorf("hash %s published without StateIncludeSignalHash", *v.SignalHash)
+		}
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	on := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+	tests := []struct {
+		name string
+		a, b *A75C4269.Controller
+		want []string
+	}{
+		{"unknown", nil, on, []string{"power", "mode", "temp", "fan", "swing"}},
+		{"same", on, on, nil},
+		{"temp", on, &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 25}, []string{"temp"}},
+		{"power and fan", on, &A75C4269.Controller{Power: A75C4269.PowerOff, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: A75C4269.AirVolume1}, []string{"power", "fan"}},
+	}
+	for _, tt := range tests {
+		if got := changedFields(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestPublishLastChanged checks only the fields which changed get a new time, and that
+// the times are kept across a restart
+func TestPublishLastChanged(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	_, restore := testConfig(t)
+	defer restore()
+	include := StateIncludeTimestamps
+	StateIncludeTimestamps = true
+	defer func() { StateIncludeTimestamps = include }()
+
+	lastChanged := func(payload []byte) map[string]time.Time {
+		v := struct {
+			LastChanged map[string]time.Time `json:"last_changed"`
+		}{}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v.LastChanged
+	}
+
+	s, states, close := testPublishing(t, broker, PubTopic)
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":26}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	first := lastChanged(receive(t, states, "state").Payload())
+	if len(first) != 5 {
+		t.Fatalf("last changed %v, want all the fields", first)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":24}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	second := lastChanged(receive(t, states, "state").Payload())
+	for f, at := range second {
+		if changed := !at.Equal(first[f]); changed != (f == "temp") {
+			t.Errorf("%s: %v after %v, want a new time for temp only", f, at, first[f])
+		}
+	}
+	close()
+
+	// the times are read back from StateFile after a restart
+	s = newService(testApp(t), nil)
+	for f, at := range second {
+		if !s.lastChanged[f].Equal(at) {
+			t.Errorf("%s: %v after a restart, want %v", f, s.lastChanged[f], at)
+		}
+	}
+}
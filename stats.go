@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats are the transmission statistics since startup
+type Stats struct {
+	Sends         int        `json:"sends"`
+	Successes     int        `json:"successes"`
+	Failures      int        `json:"failures"`
+	LastSend      *time.Time `json:"last_send,omitempty"`
+	AvgDurationMs float64    `json:"avg_duration_ms"`
+}
+
+// stats is updated by the service and read by the HTTP API
+type stats struct {
+	mu    sync.Mutex
+	stats Stats
+	total time.Duration
+}
+
+// record counts a transmission which took d and failed with err if not nil
+func (s *stats) record(at time.Time, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Sends++
+	if err != nil {
+		s.stats.Failures++
+	} else {
+		s.stats.Successes++
+	}
+	s.stats.LastSend = &at
+	s.total += d
+	s.stats.AvgDurationMs = float64(s.total) / float64(s.stats.Sends) / float64(time.Millisecond)
+}
+
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
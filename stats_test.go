@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatsRecord(t *testing.T) {
+	var s stats
+	if st := s.snapshot(); st.Sends != 0 || st.LastSend != nil {
+		t.Errorf("got %+v before any send", st)
+	}
+	first, last := time.Now(), time.Now().Add(time.Minute)
+	s.record(first, 10*time.Millisecond, nil)
+	s.record(last, 30*time.Millisecond, errors.New("failed"))
+	st := s.snapshot()
+	if st.Sends != 2 || st.Successes != 1 || st.Failures != 1 {
+		t.Errorf("got %+v, want 2 sends, 1 success, 1 failure", st)
+	}
+	if st.LastSend == nil || !st.LastSend.Equal(last) {
+		t.Errorf("last send %v, want %v", st.LastSend, last)
+	}
+	if st.AvgDurationMs != 20 {
+		t.Errorf("average %vms, want 20ms", st.AvgDurationMs)
+	}
+}
+
+// TestStatsTransmit checks each transmission is counted once, whatever its repeats
+func TestStatsTransmit(t *testing.T) {
+	_, restore := testConfig(t)
+	defer restore()
+	repeat := RepeatPower
+	RepeatPower = 2
+	defer func() { RepeatPower = repeat }()
+
+	s := newService(testApp(t), nil)
+	e := &testEmitter{name: EmitterFile}
+	s.emitters = []emitter{e}
+	if err := s.handleAction([]byte(`{"power":"on","mode":"cool","temp":25}`), sourceMQTT); err != nil {
+		t.Fatal(err)
+	}
+	e.err = errors.New("failed")
+	s.handleAction([]byte(`{"power":"off"}`), sourceMQTT)
+
+	st := s.stats.snapshot()
+	if st.Sends != 2 || st.Successes != 1 || st.Failures != 1 {
+		t.Errorf("got %+v, want 2 sends, 1 success, 1 failure", st)
+	}
+	if e.sends != 3 {
+		t.Errorf("%d frames sent, want 2 then 1 failed", e.sends)
+	}
+	if s.view.snapshot().Available {
+		t.Error("unit available after a failed send")
+	}
+}
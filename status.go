@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// how long each request of the status command may take
+const statusTimeout = 5 * time.Second
+
+// InstanceStatus is what the status command shows of a running instance
+type InstanceStatus struct {
+	Version *VersionInfo
+	Unit    *UnitState
+	Stats   *Stats
+	// Energy is nil without ENERGY_WATTS
+	Energy *EnergyEstimate
+	// Schedule is nil when the instance does not serve SchedulePath
+	Schedule []*ScheduledCommand
+	// History is the latest commands of the journal, nil without one
+	History []JournalEntry
+}
+
+// runStatus is the status subcommand: it prints the status of the instance running on
+// the same host, read from its HTTP API and its journal, once or every interval
+func runStatus(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	base := flags.String("url", statusURL(HTTPAddr), "URL of the HTTP API of the instance")
+	journal := flags.String("journal", JournalFile, "Journal file to read the recent commands from")
+	history := flags.Int("history", 5, "Number of recent commands shown")
+	watch := flags.Bool("watch", false, "Refresh until interrupted")
+	interval := flags.Duration("interval", 2*time.Second, "Refresh interval with -watch")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*base) == 0 {
+		return errors.New("no HTTP API: set -url or HTTP_ADDR")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("invalid interval: %v", *interval)
+	}
+	client := &http.Client{Timeout: statusTimeout}
+
+	for {
+		st, err := fetchStatus(client, strings.TrimRight(*base, "/"), *journal, *history)
+		if !*watch {
+			if err != nil {
+				return err
+			}
+			writeStatus(w, *base, st)
+			return nil
+		}
+		// clear the terminal and start from its top
+		fmt.Fprint(w, "\x1b[H\x1b[2J")
+		fmt.Fprintf(w, "every %v, updated %s\n\n", *interval, time.Now().In(Location).Format("15:04:05"))
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		} else {
+			writeStatus(w, *base, st)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// statusURL returns the URL of the HTTP API listening on addr of HTTP_ADDR, through
+// localhost when it listens on every address
+func statusURL(addr string) string {
+	if len(addr) == 0 {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// fetchStatus reads the status from the HTTP API at base and the last history entries
+// of journal if set
+func fetchStatus(client *http.Client, base, journal string, history int) (*InstanceStatus, error) {
+	st := &InstanceStatus{}
+	units := map[string]*UnitState{}
+	if _, err := getJSON(client, base+UnitsPath, &units); err != nil {
+		return nil, err
+	}
+	for _, u := range units {
+		st.Unit = u
+	}
+	if _, err := getJSON(client, base+VersionPath, &st.Version); err != nil {
+		return nil, err
+	}
+	if _, err := getJSON(client, base+StatsPath, &st.Stats); err != nil {
+		return nil, err
+	}
+	if _, err := getJSON(client, base+EnergyPath, &st.Energy); err != nil {
+		return nil, err
+	}
+	schedule := []*ScheduledCommand{}
+	if found, err := getJSON(client, base+SchedulePath, &schedule); err != nil {
+		return nil, err
+	} else if found {
+		st.Schedule = schedule
+	}
+
+	if len(journal) > 0 && history > 0 {
+		// nothing is journaled yet until the first command
+		entries, err := readJournal(journal)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(entries) > history {
+			entries = entries[len(entries)-history:]
+		}
+		st.History = append([]JournalEntry{}, entries...)
+	}
+	return st, nil
+}
+
+// getJSON decodes the reply of GET url into v, found is false on 404
+func getJSON(client *http.Client, url string, v interface{}) (found bool, err error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("GET %s: %v", url, err)
+	}
+	return true, nil
+}
+
+// writeStatus prints st of the instance at base in a few aligned lines
+func writeStatus(w io.Writer, base string, st *InstanceStatus) {
+	if st.Version != nil {
+		fmt.Fprintf(w, "aircon_ir_emitter %s (%s), remote %s at %s\n", st.Version.Version, st.Version.Commit, st.Version.Model, base)
+	}
+
+	state, available, confirmed := "unknown", "unknown", "-"
+	if st.Unit != nil {
+		if st.Unit.State != nil {
+			state = formatDecodedState(st.Unit.State)
+		}
+		available = yesNo(st.Unit.Available)
+		if st.Unit.Confirmed != nil {
+			confirmed = yesNo(*st.Unit.Confirmed)
+		}
+	}
+	fmt.Fprintf(w, "state:      %s\n", state)
+	fmt.Fprintf(w, "available:  %s (confirmed: %s)\n", available, confirmed)
+
+	if st.Stats != nil {
+		last := "never"
+		if st.Stats.LastSend != nil {
+			last = formatStatusTime(*st.Stats.LastSend)
+		}
+		fmt.Fprintf(w, "sends:      %d (%d ok, %d failed), last %s, avg %.1fms\n",
+			st.Stats.Sends, st.Stats.Successes, st.Stats.Failures, last, st.Stats.AvgDurationMs)
+	}
+	if st.Energy != nil {
+		fmt.Fprintf(w, "energy:     %.0fW now, %.2fkWh this week, %.2fkWh in total (estimated)\n",
+			st.Energy.Watts, st.Energy.Week.KWh, st.Energy.Total.KWh)
+	}
+
+	switch {
+	case st.Schedule == nil:
+		fmt.Fprintln(w, "schedule:   unknown")
+	case len(st.Schedule) == 0:
+		fmt.Fprintln(w, "schedule:   none")
+	default:
+		fmt.Fprintln(w, "schedule:")
+		for _, c := range st.Schedule {
+			line := fmt.Sprintf("  %s  %s  %s", formatStatusTime(c.At), formatDecodedState(decodeState(&c.Controller)), c.ID)
+			if len(c.Tag) > 0 {
+				line += " [" + c.Tag + "]"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	if st.History != nil {
+		if len(st.History) == 0 {
+			fmt.Fprintln(w, "history:    none")
+			return
+		}
+		fmt.Fprintln(w, "history:")
+		for _, e := range st.History {
+			fmt.Fprintf(w, "  %s  %s\n", formatStatusTime(e.Time), string(e.Command))
+		}
+	}
+}
+
+// formatDecodedState formats d as "on, cool 26℃, fan auto, swing auto", or "off"
+func formatDecodedState(d *DecodedState) string {
+	if d.Power == "off" {
+		return d.Power
+	}
+	return fmt.Sprintf("%s, %s %d℃, fan %s, swing %s", d.Power, d.Mode, d.Temp, d.Fan, d.Swing)
+}
+
+func formatStatusTime(t time.Time) string {
+	return t.In(Location).Format("2006-01-02 15:04:05")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
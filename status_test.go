@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+// statusServer serves the endpoints read by the status command, the schedule only when
+// schedule is not nil
+func statusServer(schedule []*ScheduledCommand) *httptest.Server {
+	lastSend := time.Date(2026, 7, 1, 8, 59, 30, 0, time.UTC)
+	confirmed := true
+	replies := map[string]interface{}{
+		UnitsPath: map[string]*UnitState{"living": {
+			State:     &DecodedState{Power: "on", Mode: "cool", Temp: 26, Fan: "2", Swing: "auto"},
+			Available: true,
+			Confirmed: &confirmed,
+		}},
+		VersionPath: &VersionInfo{Version: "1.2.0", Commit: "abc123", Model: RemoteModel},
+		StatsPath:   &Stats{Sends: 12, Successes: 11, Failures: 1, LastSend: &lastSend, AvgDurationMs: 41.25},
+		EnergyPath:  &EnergyEstimate{Estimated: true, Watts: 450, Week: EnergyPeriod{KWh: 3.5}, Total: EnergyPeriod{KWh: 120.25}},
+	}
+	if schedule != nil {
+		replies[SchedulePath] = schedule
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := replies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+}
+
+func TestRunStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aircon_ir_emitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	location := Location
+	Location = time.UTC
+	defer func() { Location = location }()
+
+	journal := filepath.Join(dir, "journal")
+	var lines []string
+	for i, command := range []string{`{"power":"off"}`, `{"power":"on","mode":"heat","temp":22}`, `{"power":"on","mode":"cool","temp":26,"fan":"2"}`} {
+		b, _ := json.Marshal(&JournalEntry{Time: time.Date(2026, 7, 1, 7+i, 0, 0, 0, time.UTC), Command: json.RawMessage(command)})
+		lines = append(lines, string(b))
+	}
+	if err := ioutil.WriteFile(journal, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := []*ScheduledCommand{{
+		ID:         "bedtime",
+		At:         time.Date(2026, 7, 1, 23, 0, 0, 0, time.UTC),
+		Controller: A75C4269.Controller{Power: A75C4269.PowerOff},
+	}, {
+		ID:         "r1@2026-07-02T07:00",
+		At:         time.Date(2026, 7, 2, 7, 0, 0, 0, time.UTC),
+		Controller: A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 27, AirVolume: A75C4269.AirVolumeAuto},
+		Tag:        TagBoost,
+	}}
+
+	tests := []struct {
+		name     string
+		schedule []*ScheduledCommand
+		args     []string
+		want     string
+	}{
+		{"everything", schedule, []string{"-journal", journal, "-history", "2"}, `aircon_ir_emitter 1.2.0 (abc123), remote ` + RemoteModel + ` at URL
+state:      on, cool 26℃, fan 2, swing auto
+available:  yes (confirmed: yes)
+sends:      12 (11 ok, 1 failed), last 2026-07-01 08:59:30, avg 41.2ms
+energy:     450W now, 3.50kWh this week, 120.25kWh in total (estimated)
+schedule:
+  2026-07-01 23:00:00  off  bedtime
+  2026-07-02 07:00:00  on, cool 27℃, fan auto, swing auto  r1@2026-07-02T07:00 [boost]
+history:
+  2026-07-01 08:00:00  {"power":"on","mode":"heat","temp":22}
+  2026-07-01 09:00:00  {"power":"on","mode":"cool","temp":26,"fan":"2"}
+`},
+		{"no schedule served nor journal", nil, []string{"-journal", ""}, `aircon_ir_emitter 1.2.0 (abc123), remote ` + RemoteModel + ` at URL
+state:      on, cool 26℃, fan 2, swing auto
+available:  yes (confirmed: yes)
+sends:      12 (11 ok, 1 failed), last 2026-07-01 08:59:30, avg 41.2ms
+energy:     450W now, 3.50kWh this week, 120.25kWh in total (estimated)
+schedule:   unknown
+`},
+		{"nothing scheduled nor journaled yet", []*ScheduledCommand{}, []string{"-journal", filepath.Join(dir, "none")}, `aircon_ir_emitter 1.2.0 (abc123), remote ` + RemoteModel + ` at URL
+state:      on, cool 26℃, fan 2, swing auto
+available:  yes (confirmed: yes)
+sends:      12 (11 ok, 1 failed), last 2026-07-01 08:59:30, avg 41.2ms
+energy:     450W now, 3.50kWh this week, 120.25kWh in total (estimated)
+schedule:   none
+history:    none
+`},
+	}
+	for _, tt := range tests {
+		server := statusServer(tt.schedule)
+		var out bytes.Buffer
+		err := runStatus(append([]string{"-url", server.URL}, tt.args...), &out)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := strings.Replace(tt.want, "at URL", "at "+server.URL, 1); out.String() != want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, out.String(), want)
+		}
+	}
+}
+
+func TestRunStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	err := runStatus([]string{"-url", server.URL, "-journal", ""}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got %v, want the status of the API", err)
+	}
+}
+
+func TestStatusURL(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{"", ""},
+		{":8080", "http://localhost:8080"},
+		{"0.0.0.0:8080", "http://localhost:8080"},
+		{"[::]:8080", "http://localhost:8080"},
+		{"192.168.1.5:80", "http://192.168.1.5:80"},
+		{"invalid", ""},
+	}
+	for _, tt := range tests {
+		if got := statusURL(tt.addr); got != tt.want {
+			t.Errorf("statusURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/djthorpe/gopi"
+	"io"
+	"io/ioutil"
+)
+
+// runStdin emits a single command read from r without connecting to the broker,
+// then writes the decoded state to w. A command which is rejected or not sent is
+// returned as an error.
+func runStdin(app *gopi.AppInstance, r io.Reader, w io.Writer) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s := newService(app, nil)
+	defer s.notifying.Wait()
+
+	cmd := Command{}
+	if err := json.Unmarshal(b, &cmd); err != nil {
+		return err
+	}
+
+	if len(cmd.Raw) > 0 {
+		if err := cmd.ValidateRaw(); err != nil {
+			return err
+		}
+		return s.emitRaw(cmd.Raw)
+	}
+
+	// the same stages as a command from the broker, without its filters
+	c, err := cmd.Resolve(s.last)
+	if err == nil {
+		c, err = s.transform(s.commandPipeline(&cmd), s.last, c)
+	}
+	if err != nil {
+		return err
+	}
+
+	// emit reports its rejections through fail only, they are recorded to exit non-zero
+	conf := &confirmation{done: make(chan struct{})}
+	s.confirming = conf
+	err = s.emit(c)
+	s.confirming = nil
+	switch {
+	case err != nil:
+		return err
+	case conf.rejected != nil:
+		return conf.rejected
+	case !conf.sent:
+		return errors.New("command not sent")
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(decodeState(c))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStdin(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		state   *DecodedState
+		err     string
+		// forbids powering on all day
+		forbid bool
+	}{
+		{"sent", `{"power":"on","mode":"cool","temp":26}`, &DecodedState{Power: "on", Mode: "cool", Temp: 26, Fan: "auto", Swing: "auto"}, "", false},
+		{"snapped", `{"power":"on","mode":"heat","temp":22.4}`, &DecodedState{Power: "on", Mode: "heat", Temp: 22, Fan: "auto", Swing: "auto"}, "", false},
+		{"out of range", `{"power":"on","mode":"cool","temp":40}`, nil, "temp", false},
+		{"invalid json", `{"power":`, nil, "unexpected end", false},
+		{"forbidden hours", `{"power":"on","mode":"cool","temp":26}`, nil, "FORBID_HOURS", true},
+		{"power off in forbidden hours", `{"power":"off"}`, &DecodedState{Power: "off", Mode: "cool", Temp: 0, Fan: "auto", Swing: "auto"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, restore := testConfig(t)
+			defer restore()
+			if tt.forbid {
+				forbid := ForbidHours
+				ForbidHours = []hourWindow{{from: 0, to: 24 * time.Hour}}
+				defer func() { ForbidHours = forbid }()
+			}
+
+			var out bytes.Buffer
+			err := runStdin(testApp(t), strings.NewReader(tt.command), &out)
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want an error with %q", err, tt.err)
+				}
+				if n := emittedFrames(t); n != 0 {
+					t.Errorf("%d frames emitted for a rejected command", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := DecodedState{}
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != *tt.state {
+				t.Errorf("got %+v, want %+v", got, *tt.state)
+			}
+			if n := emittedFrames(t); n != 1 {
+				t.Errorf("%d frames emitted, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// a connection lost this soon after it was made counts as taken over
+	takeoverWindow = 5 * time.Second
+	// connections taken over in a row before warning about the client id
+	takeoverWarnAfter = 3
+)
+
+// takeover detects connections which are lost right after being made, as when the
+// broker disconnects the client for another process connecting with the same client id
+type takeover struct {
+	clientID    string
+	mutex       sync.Mutex
+	connectedAt time.Time
+	count       int
+}
+
+func (t *takeover) connected() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.connectedAt = time.Now()
+}
+
+// lost counts the connections lost within takeoverWindow in a row, and warns once
+// every takeoverWarnAfter of them
+func (t *takeover) lost() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.connectedAt.IsZero() || time.Since(t.connectedAt) > takeoverWindow {
+		t.count = 0
+		return
+	}
+	t.count++
+	if t.count%takeoverWarnAfter == 0 {
+		log.Printf("mqtt connection lost %d times in a row within %v of connecting: client id %s may be used by another process, "+
+			"give each a different id or set MQTT_CLIENT_ID_RANDOM_SUFFIX=true", t.count, takeoverWindow, t.clientID)
+	}
+}
+
+// randomSuffix returns 8 random hex digits
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
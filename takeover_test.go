@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects the output of the standard logger, which goroutines left by
+// other tests may write to at the same time
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// count returns the lines containing s
+func (b *logBuffer) count(s string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		if strings.Contains(line, s) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTakeoverWarning(t *testing.T) {
+	var logs logBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	const warning = "client id aircon_test may be used by another process"
+
+	tk := &takeover{clientID: "aircon_test"}
+	// lost before ever connecting
+	tk.lost()
+	for i := 1; i < takeoverWarnAfter; i++ {
+		tk.connected()
+		tk.lost()
+	}
+	if n := logs.count(warning); n != 0 {
+		t.Fatalf("warned after %d losses", takeoverWarnAfter-1)
+	}
+	tk.connected()
+	tk.lost()
+	if n := logs.count(warning); n != 1 {
+		t.Fatalf("warned %d times after %d losses, want 1", n, takeoverWarnAfter)
+	}
+
+	// a connection which lasted resets the count
+	tk.connected()
+	tk.mutex.Lock()
+	tk.connectedAt = time.Now().Add(-takeoverWindow - time.Second)
+	tk.mutex.Unlock()
+	tk.lost()
+	for i := 1; i < takeoverWarnAfter; i++ {
+		tk.connected()
+		tk.lost()
+	}
+	if n := logs.count(warning); n != 1 {
+		t.Fatalf("warned %d times, want the count reset by a lasting connection", n)
+	}
+	tk.connected()
+	tk.lost()
+	if n := logs.count(warning); n != 2 {
+		t.Errorf("warned %d times, want 2", n)
+	}
+}
+
+func TestMQTTClientID(t *testing.T) {
+	group, random := MQTTSharedGroup, MQTTClientIDRandomSuffix
+	defer func() { MQTTSharedGroup, MQTTClientIDRandomSuffix = group, random }()
+	MQTTSharedGroup = ""
+
+	MQTTClientIDRandomSuffix = false
+	if got := mqttClientID(); got != ClientID {
+		t.Errorf("got %s, want %s", got, ClientID)
+	}
+	MQTTClientIDRandomSuffix = true
+	a, b := mqttClientID(), mqttClientID()
+	if !regexp.MustCompile(`^` + ClientID + `_[0-9a-f]{8}$`).MatchString(a) {
+		t.Errorf("got %s, want %s_ and 8 hex digits", a, ClientID)
+	}
+	if a == b {
+		t.Errorf("same suffix twice: %s", a)
+	}
+}
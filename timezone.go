@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// layouts accepted for times without an offset, interpreted in Location
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// LocalTime is a time given as RFC3339, or without offset in Location
+type LocalTime struct {
+	time.Time
+}
+
+func (t *LocalTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if v, err := time.Parse(time.RFC3339, s); err == nil {
+		t.Time = v
+		return nil
+	}
+	for _, layout := range localTimeLayouts {
+		if v, err := time.ParseInLocation(layout, s, Location); err == nil {
+			t.Time = v
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time: %s", s)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/wtks/A75C4269"
+)
+
+// Transform turns the state resolved from a command into the state to emit, given the
+// last state, which may be nil
+type Transform func(prev, in *A75C4269.Controller) (*A75C4269.Controller, error)
+
+// transformStage is a named Transform of a pipeline
+type transformStage struct {
+	name      string
+	transform Transform
+}
+
+// commandPipeline returns the stages applied in order to the state of a plain or preset
+// command once it is resolved:
+//
+//	merge     a command with power on only takes the last powered on state
+//	snap      the temperature is rounded to the nearest step of Spec
+//	validate  the state is checked against Spec
+//	sanity    the state is checked against the enabled sanity rules
+//
+// Names, aliases and fractional temperatures were normalized before, when the command
+// was decoded. Filters such as MinTempChange and DedupWindow come after the pipeline.
+//
+// The setpoint branch of handleAction does not run the pipeline, so it skips the sanity
+// stage: resolveSetpoint validates the state itself and picks the mode from the same
+// ambient and outside temperatures the rules check.
+func (s *service) commandPipeline(cmd *Command) []transformStage {
+	return []transformStage{
+		{"merge", func(prev, in *A75C4269.Controller) (*A75C4269.Controller, error) {
+			if r := cmd.Restore(s.lastOn); r != nil {
+				s.app.Logger.Info("power on restoring the previous state")
+				return r, nil
+			}
+			return in, nil
+		}},
+		{"snap", snapTransform},
+		{"validate", validateTransform},
+		{"sanity", s.sanityTransform},
+	}
+}
+
+func snapTransform(prev, in *A75C4269.Controller) (*A75C4269.Controller, error) {
+	c := *in
+	c.PresetTemp = Spec.SnapTemp(c.Mode, float64(c.PresetTemp))
+	return &c, nil
+}
+
+func validateTransform(prev, in *A75C4269.Controller) (*A75C4269.Controller, error) {
+	return in, Spec.Check(in)
+}
+
+// transform runs in through the stages in order, logging the stages which change it
+func (s *service) transform(stages []transformStage, prev, in *A75C4269.Controller) (*A75C4269.Controller, error) {
+	c := in
+	for _, stage := range stages {
+		out, err := stage.transform(prev, c)
+		if err != nil {
+			return nil, err
+		}
+		if *out != *c {
+			s.app.Logger.Debug("%s: %+v to %+v", stage.name, *c, *out)
+		}
+		c = out
+	}
+	return c, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wtks/A75C4269"
+)
+
+// TestTransform checks that the stages run in order on the output of the previous one,
+// and that an error stops the pipeline
+func TestTransform(t *testing.T) {
+	s := newService(testApp(t), nil)
+	prev := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 20}
+	in := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}
+
+	var ran []string
+	stage := func(name string, f func(c *A75C4269.Controller) error) transformStage {
+		return transformStage{name, func(p, in *A75C4269.Controller) (*A75C4269.Controller, error) {
+			ran = append(ran, name)
+			if p != prev {
+				t.Errorf("%s: prev %+v", name, p)
+			}
+			c := *in
+			return &c, f(&c)
+		}}
+	}
+	warmer := stage("warmer", func(c *A75C4269.Controller) error { c.PresetTemp++; return nil })
+	fan := stage("fan", func(c *A75C4269.Controller) error { c.AirVolume = A75C4269.AirVolume2; return nil })
+	reject := stage("reject", func(c *A75C4269.Controller) error { return errors.New("rejected") })
+
+	c, err := s.transform([]transformStage{warmer, fan, warmer}, prev, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 28, AirVolume: A75C4269.AirVolume2}
+	if *c != want {
+		t.Errorf("got %+v, want %+v", *c, want)
+	}
+	if in.PresetTemp != 26 {
+		t.Errorf("input modified to %+v", *in)
+	}
+	if want := []string{"warmer", "fan", "warmer"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+
+	ran = nil
+	if c, err := s.transform([]transformStage{warmer, reject, fan}, prev, in); err == nil || c != nil {
+		t.Errorf("got %+v, %v, want the error of reject", c, err)
+	}
+	if want := []string{"warmer", "reject"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+// TestCommandPipeline runs resolved commands through the merge, snap and validate stages
+func TestCommandPipeline(t *testing.T) {
+	spec := Spec
+	Spec = testSpec()
+	defer func() { Spec = spec }()
+	if err := Spec.SetTempRange(MinTemp, MaxTemp, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	lastOn := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeHeater, PresetTemp: 22, AirVolume: A75C4269.AirVolume3}
+	invalid := &A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26, AirVolume: 9}
+	tests := []struct {
+		name    string
+		lastOn  *A75C4269.Controller
+		payload string
+		in      A75C4269.Controller
+		want    A75C4269.Controller
+		err     string
+	}{
+		{"merge", lastOn, `{"power":"on"}`, A75C4269.Controller{Power: A75C4269.PowerOn},
+			*lastOn, ""},
+		{"snap", lastOn, `{"power":"on","mode":"cool","temp":24}`, A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 25},
+			A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 26}, ""},
+		{"validate", lastOn, `{"power":"on","mode":"cool","temp":24}`, A75C4269.Controller{Power: A75C4269.PowerOn, Mode: A75C4269.ModeCooler, PresetTemp: 34},
+			A75C4269.Controller{}, "temperature out of range for cool: 34"},
+		{"merged state validated", invalid, `{"power":"on"}`, A75C4269.Controller{Power: A75C4269.PowerOn},
+			A75C4269.Controller{}, "invalid fan"},
+	}
+	for _, tt := range tests {
+		s := newService(testApp(t), nil)
+		s.lastOn = tt.lastOn
+		var cmd Command
+		if err := json.Unmarshal([]byte(tt.payload), &cmd); err != nil {
+			t.Fatal(err)
+		}
+		in := tt.in
+		c, err := s.transform(s.commandPipeline(&cmd), nil, &in)
+		switch {
+		case len(tt.err) > 0:
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case *c != tt.want:
+			t.Errorf("%s: got %+v, want %+v", tt.name, *c, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/wtks/A75C4269"
+	"sync"
+)
+
+// UnitState is the state and availability of a unit returned by UnitsPath
+type UnitState struct {
+	// State is nil while unknown
+	State *DecodedState `json:"state"`
+	// Available is false when the last transmission failed
+	Available bool `json:"available"`
+	// Confirmed tells whether the feedback confirmed the last power-on, nil without feedback
+	Confirmed *bool `json:"confirmed,omitempty"`
+}
+
+// unitView keeps a copy of the state for readers outside the loop of the service
+type unitView struct {
+	mu        sync.Mutex
+	last      *A75C4269.Controller
+	failed    bool
+	confirmed *bool
+}
+
+func (v *unitView) setState(c *A75C4269.Controller) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.last = c
+}
+
+func (v *unitView) setFailed(failed bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.failed = failed
+}
+
+func (v *unitView) setConfirmed(confirmed bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.confirmed = &confirmed
+}
+
+func (v *unitView) snapshot() *UnitState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	u := &UnitState{Available: !v.failed, Confirmed: v.confirmed}
+	if v.last != nil {
+		u.State = decodeState(v.last)
+	}
+	return u
+}
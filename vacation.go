@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wtks/A75C4269"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// TagVacationCycle marks the start of a maintenance cycle of vacation mode
+	TagVacationCycle = "vacation_cycle"
+	// TagVacationOff marks the end of a maintenance cycle of vacation mode
+	TagVacationOff = "vacation_off"
+	// TagVacationEnd marks the end of vacation mode, nothing is sent for it
+	TagVacationEnd = "vacation_end"
+)
+
+const (
+	// longest vacation accepted
+	MaxVacationDays = 90
+	// anti-freeze heating stops this much above VacationFreezeTemp
+	vacationFreezeHysteresis = 3.0
+)
+
+// VacationCycleState is the state run during the maintenance cycles, drying by default
+// against mold
+var VacationCycleState = &A75C4269.Controller{
+	Power:      A75C4269.PowerOn,
+	Mode:       A75C4269.ModeDehumidifier,
+	PresetTemp: 28,
+}
+
+// vacation keeps the unit off until Until, but for maintenance cycles and anti-freeze
+// heating. It is persisted next to StateFile.
+type vacation struct {
+	Until time.Time `json:"until"`
+	// AntiFreeze is set while heating because the ambient temperature is near freezing
+	AntiFreeze bool `json:"anti_freeze"`
+}
+
+// parseVacationCycleState parses VACATION_CYCLE_STATE, a plain powered on state
+func parseVacationCycleState(v string) (*A75C4269.Controller, error) {
+	cmd := Command{}
+	if err := json.Unmarshal([]byte(v), &cmd); err != nil {
+		return nil, err
+	}
+	if len(cmd.Action) > 0 || len(cmd.Raw) > 0 || cmd.Boost != nil || len(cmd.Preset) > 0 || cmd.Setpoint != nil {
+		return nil, errors.New("only a plain state can be the cycle state")
+	}
+	if cmd.Power != A75C4269.PowerOn {
+		return nil, errors.New("the cycle state must be powered on")
+	}
+	if err := Spec.Check(&cmd.Controller); err != nil {
+		return nil, err
+	}
+	return &cmd.Controller, nil
+}
+
+// vacationPath is where vacation mode is kept next to StateFile
+func vacationPath() string {
+	return StateFile + ".vacation"
+}
+
+func loadVacation(path string) (*vacation, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v := &vacation{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// saveVacation persists vacation mode, removing the file once it is over
+func (s *service) saveVacation() {
+	if len(StateFile) == 0 {
+		return
+	}
+	var err error
+	if s.vacation == nil {
+		if err = os.Remove(vacationPath()); os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		b, _ := json.Marshal(s.vacation)
+		err = writeFileAtomic(vacationPath(), b)
+	}
+	if err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+}
+
+// startVacation powers the unit off and plans the maintenance cycles until cmd.Until
+func (s *service) startVacation(cmd *Command) error {
+	if cmd.Until == nil {
+		s.fail(errors.New("vacation requires until"))
+		return nil
+	}
+	until := cmd.Until.Time
+	if !until.After(time.Now()) || until.Sub(time.Now()) > MaxVacationDays*24*time.Hour {
+		s.fail(fmt.Errorf("vacation until out of range: %v (within %d days)", until.In(Location), MaxVacationDays))
+		return nil
+	}
+
+	s.cancelInternal()
+	s.vacation = &vacation{Until: until}
+	s.saveVacation()
+	s.app.Logger.Info("vacation until %v", until.In(Location))
+	s.planVacation(time.Now())
+	s.publishSchedule()
+	return s.submit(s.offState())
+}
+
+// endVacation leaves vacation mode and drops its pending cycles, the state of the
+// unit is left as it is
+func (s *service) endVacation(reason string) {
+	s.app.Logger.Info("vacation ended: %s", reason)
+	s.vacation = nil
+	s.saveVacation()
+	cancelled := false
+	for _, c := range s.schedule.list() {
+		if vacationTag(c.Tag) {
+			if _, err := s.schedule.cancel(c.ID); err != nil {
+				s.app.Logger.Error(err.Error())
+			}
+			cancelled = true
+		}
+	}
+	if cancelled {
+		s.publishSchedule()
+	}
+}
+
+// planVacation schedules the end of vacation mode and its next cycle after t, unless
+// they are already pending
+func (s *service) planVacation(t time.Time) {
+	var cycle, end bool
+	for _, c := range s.schedule.list() {
+		cycle = cycle || c.Tag == TagVacationCycle
+		end = end || c.Tag == TagVacationEnd
+	}
+	if !end {
+		if err := s.schedule.add(&ScheduledCommand{At: s.vacation.Until, Controller: *s.offState(), Tag: TagVacationEnd}); err != nil {
+			s.app.Logger.Error(err.Error())
+		}
+	}
+	if !cycle && VacationCycleInterval > 0 {
+		at := t.Add(VacationCycleInterval)
+		if at.Before(s.vacation.Until) {
+			if err := s.schedule.add(&ScheduledCommand{At: at, Controller: *VacationCycleState, Tag: TagVacationCycle}); err != nil {
+				s.app.Logger.Error(err.Error())
+			}
+		}
+	}
+}
+
+// fireVacation handles a scheduled command of vacation mode which is due, cycles are
+// skipped while heating against freezing
+func (s *service) fireVacation(c *ScheduledCommand) error {
+	if s.vacation == nil {
+		return nil
+	}
+	switch c.Tag {
+	case TagVacationEnd:
+		s.endVacation("until reached")
+		return nil
+	case TagVacationOff:
+		if s.vacation.AntiFreeze {
+			return nil
+		}
+		s.app.Logger.Info("vacation: maintenance cycle over")
+		return s.submit(s.offState())
+	}
+
+	defer s.publishSchedule()
+	defer s.planVacation(c.At)
+	if s.vacation.AntiFreeze {
+		s.app.Logger.Info("vacation: maintenance cycle skipped while heating against freezing")
+		return nil
+	}
+	s.app.Logger.Info("vacation: maintenance cycle for %v", VacationCycleDuration)
+	off := c.Controller
+	off.Power = A75C4269.PowerOff
+	if err := s.schedule.add(&ScheduledCommand{At: c.At.Add(VacationCycleDuration), Controller: off, Tag: TagVacationOff}); err != nil {
+		s.app.Logger.Error(err.Error())
+	}
+	controller := c.Controller
+	return s.submit(&controller)
+}
+
+// guardFreeze heats while in vacation mode when the ambient temperature falls to
+// VacationFreezeTemp, and powers off again once it is vacationFreezeHysteresis above
+func (s *service) guardFreeze(ambient float64) error {
+	if s.vacation == nil {
+		return nil
+	}
+	switch {
+	case !s.vacation.AntiFreeze && ambient <= VacationFreezeTemp:
+		s.app.Logger.Warn("vacation: %.1f℃, heating against freezing", ambient)
+		s.vacation.AntiFreeze = true
+		s.saveVacation()
+		return s.submit(&A75C4269.Controller{
+			Power:      A75C4269.PowerOn,
+			Mode:       A75C4269.ModeHeater,
+			PresetTemp: Spec.TempRanges[A75C4269.ModeHeater].Min,
+		})
+	case s.vacation.AntiFreeze && ambient >= VacationFreezeTemp+vacationFreezeHysteresis:
+		s.app.Logger.Info("vacation: %.1f℃, anti-freeze heating over", ambient)
+		s.vacation.AntiFreeze = false
+		s.saveVacation()
+		return s.submit(s.offState())
+	}
+	return nil
+}
+
+// offState is the last state powered off
+func (s *service) offState() *A75C4269.Controller {
+	off := A75C4269.Controller{Power: A75C4269.PowerOff}
+	if s.last != nil {
+		off = *s.last
+		off.Power = A75C4269.PowerOff
+	}
+	return &off
+}
+
+// vacationTag tells whether tag marks a scheduled command of vacation mode
+func vacationTag(tag string) bool {
+	switch tag {
+	case TagVacationCycle, TagVacationOff, TagVacationEnd:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package main
+
+// set at build time with -ldflags "-X main.Version=... -X main.Commit=..."
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+const (
+	// RemoteModel is the remote emulated by the encoder
+	RemoteModel = "A75C4269"
+)
+
+// VersionInfo is published on VersionTopic and returned by VersionPath
+type VersionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Model   string `json:"model"`
+}
+
+func versionInfo() *VersionInfo {
+	return &VersionInfo{Version: Version, Commit: Commit, Model: RemoteModel}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// watchdog exits the process when the MQTT client stays disconnected for too long,
+// so that a supervisor such as systemd or Docker can restart it
+type watchdog struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	lostAt time.Time
+}
+
+func newWatchdog(timeout time.Duration) *watchdog {
+	return &watchdog{timeout: timeout}
+}
+
+func (w *watchdog) connected() {
+	w.mu.Lock()
+	w.lostAt = time.Time{}
+	w.mu.Unlock()
+}
+
+func (w *watchdog) lost() {
+	w.mu.Lock()
+	if w.lostAt.IsZero() {
+		w.lostAt = time.Now()
+	}
+	w.mu.Unlock()
+}
+
+func (w *watchdog) run() {
+	for range time.Tick(time.Second) {
+		w.mu.Lock()
+		lostAt := w.lostAt
+		w.mu.Unlock()
+
+		if !lostAt.IsZero() && time.Since(lostAt) > w.timeout {
+			exit(ExitMQTT, fmt.Sprintf("mqtt disconnected for %v", time.Since(lostAt).Truncate(time.Second)))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/djthorpe/gopi"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a request to WeatherURL may take
+const weatherTimeout = 10 * time.Second
+
+// weather keeps the outside temperature fetched from WeatherURL every WeatherInterval.
+// It is fetched by its own goroutine and read by the loop.
+type weather struct {
+	log gopi.Logger
+	mu  sync.Mutex
+	// latest reading and when it was fetched, nil before the first
+	value *float64
+	at    time.Time
+	// whether the last fetch failed, so that a failure is logged once
+	failing bool
+}
+
+// newWeather returns the weather of WeatherURL, nil unless it is set
+func newWeather(log gopi.Logger) *weather {
+	if len(WeatherURL) == 0 {
+		return nil
+	}
+	return &weather{log: log}
+}
+
+// run fetches the temperature now then every WeatherInterval. It never returns.
+func (w *weather) run() {
+	for {
+		v, err := fetchWeather(WeatherURL, WeatherKey, WeatherTempField)
+		w.mu.Lock()
+		if err != nil {
+			if !w.failing {
+				w.log.Warn("weather: %v, deciding without the outside temperature until it recovers", err)
+			}
+			w.failing = true
+		} else {
+			if w.failing || w.value == nil {
+				w.log.Info("weather: outside temperature %.1f℃", v)
+			}
+			w.value, w.at, w.failing = &v, time.Now(), false
+		}
+		w.mu.Unlock()
+		time.Sleep(WeatherInterval)
+	}
+}
+
+// outside returns the latest outside temperature, nil when w is nil, nothing was
+// fetched yet or the reading is older than 3 intervals so that decisions fail open
+func (w *weather) outside() *float64 {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.value == nil || time.Since(w.at) > 3*WeatherInterval {
+		return nil
+	}
+	v := *w.value
+	return &v
+}
+
+// fetchWeather gets url, with {key} replaced by key, and returns the temperature of the
+// reply: a bare number, or the number at field, a dotted path into the JSON
+func fetchWeather(url, key, field string) (float64, error) {
+	client := http.Client{Timeout: weatherTimeout}
+	res, err := client.Get(strings.Replace(url, "{key}", key, -1))
+	if err != nil {
+		// the error names the URL, which may hold the key
+		if len(key) > 0 {
+			return 0, errors.New(strings.Replace(err.Error(), key, "****", -1))
+		}
+		return 0, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("weather API returned %s", res.Status)
+	}
+	return weatherTemp(b, field)
+}
+
+// weatherTemp finds the temperature in b as fetchWeather does. Array elements are
+// given by their index, e.g. "hourly.temperature_2m.0".
+func weatherTemp(b []byte, field string) (float64, error) {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64); err == nil {
+		return v, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return 0, fmt.Errorf("invalid weather reply: %v", err)
+	}
+	for _, name := range strings.Split(field, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[name]
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(node) {
+				return 0, fmt.Errorf("no %s in the weather reply", field)
+			}
+			v = node[i]
+		default:
+			v = nil
+		}
+		if v == nil {
+			return 0, fmt.Errorf("no %s in the weather reply", field)
+		}
+	}
+	t, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s of the weather reply is not a number: %v", field, v)
+	}
+	return t, nil
+}
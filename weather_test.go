@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wtks/A75C4269"
+)
+
+func TestWeatherTemp(t *testing.T) {
+	tests := []struct {
+		name, body, field string
+		want              float64
+		err               string
+	}{
+		{"bare number", " 12.5\n", "current.temperature_2m", 12.5, ""},
+		{"open-meteo", `{"current":{"time":"2026-07-01T09:00","temperature_2m":28.4}}`, "current.temperature_2m", 28.4, ""},
+		{"array element", `{"hourly":{"temperature_2m":[3.5,4.1]}}`, "hourly.temperature_2m.1", 4.1, ""},
+		{"top level", `{"temp":-2}`, "temp", -2, ""},
+		{"missing field", `{"current":{}}`, "current.temperature_2m", 0, "no current.temperature_2m in the weather reply"},
+		{"index out of range", `{"hourly":{"temperature_2m":[3.5]}}`, "hourly.temperature_2m.1", 0, "no hourly.temperature_2m.1"},
+		{"index of an object", `{"hourly":{"temperature_2m":{"a":1}}}`, "hourly.temperature_2m.0", 0, "no hourly.temperature_2m.0"},
+		{"path through a number", `{"current":5}`, "current.temperature_2m", 0, "no current.temperature_2m"},
+		{"not a number", `{"current":{"temperature_2m":"warm"}}`, "current.temperature_2m", 0, "is not a number: warm"},
+		{"not json", `<html>`, "current.temperature_2m", 0, "invalid weather reply"},
+	}
+	for _, tt := range tests {
+		got, err := weatherTemp([]byte(tt.body), tt.field)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestFetchWeather(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("apikey") != "secret" {
+			http.Error(w, "invalid key", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"current":{"temperature_2m":8.5}}`)
+	}))
+	defer server.Close()
+
+	if v, err := fetchWeather(server.URL+"/forecast?apikey={key}", "secret", WeatherTempField); err != nil || v != 8.5 {
+		t.Errorf("got %v, %v, want 8.5", v, err)
+	}
+	if _, err := fetchWeather(server.URL+"/forecast?apikey={key}", "wrong", WeatherTempField); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v, want the status of the reply", err)
+	}
+
+	// the key stays out of the error naming the URL
+	url := server.URL
+	server.Close()
+	if _, err := fetchWeather(url+"/forecast?apikey={key}", "secret", WeatherTempField); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("got %v, want an error without the key", err)
+	}
+}
+
+// TestWeatherDecision checks the outside temperature fetched from the weather endpoint
+// changes the mode a setpoint picks: the room is cold, but it is not heated once it is
+// warm outside
+func TestWeatherDecision(t *testing.T) {
+	tests := []struct {
+		outside float64
+		mode    byte
+	}{
+		{5, A75C4269.ModeHeater},
+		{OutsideNoHeatAbove + 2, A75C4269.ModeDehumidifier},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"current":{"temperature_2m":%v}}`, tt.outside)
+		}))
+		v, err := fetchWeather(server.URL, "", WeatherTempField)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, restore := testConfig(t)
+		s := newService(testApp(t), nil)
+		s.weather = &weather{log: s.app.Logger, value: &v, at: time.Now()}
+		ambient := 17.0
+		s.ambient = &ambient
+		if err := s.handleAction([]byte(`{"setpoint":{"temp":22,"mode":"dry"}}`), sourceHTTP); err != nil {
+			t.Fatal(err)
+		}
+		if s.last == nil || s.last.Mode != tt.mode {
+			t.Errorf("%v℃ outside: state %+v, want mode %d", tt.outside, s.last, tt.mode)
+		}
+		restore()
+	}
+}